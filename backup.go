@@ -0,0 +1,307 @@
+package riak
+
+import (
+	"compress/gzip"
+	"http"
+	"io"
+	"io/ioutil"
+	"json"
+	"os"
+	"strings"
+)
+
+// Backups are newline-delimited JSON: one BackupRecord per key, with values
+// base64 encoded (as json does for []byte), optionally gzip compressed.
+
+type BackupSibling struct {
+	// Content-Type, Content-Encoding, Link, X-Riak-Meta-* and X-Riak-Index-* headers
+	// (plus Etag and Last-Modified, which are informational only)
+	Header http.Header
+	Value  []byte
+}
+
+type BackupRecord struct {
+	Bucket   string
+	Key      string
+	Vclock   string
+	Siblings []BackupSibling
+}
+
+type BackupStats struct {
+	Keys     int
+	Siblings int
+	Skipped  int
+	Errors   int
+}
+
+// What Restore does when a key already exists
+type RestorePolicy int
+
+const (
+	// Leave the existing value alone
+	RestoreSkipExisting RestorePolicy = iota
+	// Replace the existing value (and any siblings) with the archived one(s)
+	RestoreOverwrite
+	// Write with the archived vclock; riak will create siblings if the value
+	// has changed since the backup
+	RestoreKeepVclock
+)
+
+// Parses the command-line names of the policies: skip-existing, overwrite
+// or keep-vclock.
+func ParseRestorePolicy(s string) (p RestorePolicy, err os.Error) {
+	switch s {
+	case "skip-existing":
+		p = RestoreSkipExisting
+	case "overwrite":
+		p = RestoreOverwrite
+	case "keep-vclock":
+		p = RestoreKeepVclock
+	default:
+		err = os.NewError("Unknown restore policy: " + s)
+	}
+	return
+}
+
+type BackupOptions struct {
+	Gzip bool
+	// If non-nil, errors fetching individual keys are reported here and the
+	// backup continues; otherwise the first error stops the backup.
+	OnError func(bucket, key string, err os.Error)
+}
+
+type RestoreOptions struct {
+	Gzip   bool
+	Policy RestorePolicy
+	// As BackupOptions.OnError
+	OnError func(bucket, key string, err os.Error)
+}
+
+var backupHeaders = []string{"Content-Type", "Content-Encoding", "Etag", "Last-Modified"}
+
+func backupHeader(in http.Header) (out http.Header) {
+	out = http.Header{}
+	for _, k := range backupHeaders {
+		if v, ok := in[k]; ok {
+			out[k] = v
+		}
+	}
+	for k, v := range in {
+		if strings.HasPrefix(k, "X-Riak-Meta-") || strings.HasPrefix(k, "X-Riak-Index-") {
+			out[k] = v
+		}
+	}
+	// riak always adds the rel="up" link to the bucket; only keep real links.
+	for _, hv := range in["Link"] {
+		for _, l := range strings.Split(hv, ",") {
+			if l = strings.TrimSpace(l); l != "" && !strings.Contains(l, "rel=\"up\"") {
+				out.Add("Link", l)
+			}
+		}
+	}
+	return
+}
+
+// Fetches every sibling of bucket/key.
+func FetchRecord(c Client, bucket, key string) (rec BackupRecord, err os.Error) {
+	return fetchRecord(c, bucket, key, nil)
+}
+
+func fetchRecord(c Client, bucket, key string, cc *http.ClientConn) (rec BackupRecord, err os.Error) {
+	rec = BackupRecord{Bucket: bucket, Key: key}
+	respch := make(chan *http.Response)
+	done := make(chan os.Error)
+	go func() {
+		var rerr os.Error
+		for resp := range respch {
+			value, e := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if e != nil && rerr == nil {
+				rerr = e
+			}
+			rec.Vclock = resp.Header.Get("X-Riak-Vclock")
+			rec.Siblings = append(rec.Siblings, BackupSibling{Header: backupHeader(resp.Header), Value: value})
+		}
+		done <- rerr
+	}()
+	// with */* riak answers with the value itself when there's only one.
+	hdrs := http.Header{"Accept": []string{"multipart/mixed, */*;q=0.5"}}
+	err = GetMultiItem(c, bucket, key, hdrs, nil, respch, cc)
+	if rerr := <-done; err == nil {
+		err = rerr
+	}
+	return
+}
+
+// Writes every key in buckets to w.  The keys are fetched over a single
+// connection.
+func Backup(c Client, buckets []string, w io.Writer, opts BackupOptions) (stats BackupStats, err os.Error) {
+	if opts.Gzip {
+		var gz *gzip.Compressor
+		if gz, err = gzip.NewWriter(w); err != nil {
+			return
+		}
+		// the trailer is written by Close; without it the backup is truncated
+		defer func() {
+			if cerr := gz.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = gz
+	}
+	var cc *http.ClientConn
+	defer func() {
+		if cc != nil {
+			cc.Close()
+		}
+	}()
+	enc := json.NewEncoder(w)
+	for _, b := range buckets {
+		// canceled to stop the listing after an error
+		lc, ctx := c.withChildContext()
+		keys := make(chan string, 256)
+		listErr := make(chan os.Error, 1)
+		go func(b string) { listErr <- ListKeys(lc, b, keys, nil) }(b)
+		for k := range keys {
+			if err != nil {
+				continue // drain what was listed before the cancel
+			}
+			if cc == nil {
+				cc = batchConn(c)
+			}
+			var rec BackupRecord
+			rec, err = fetchRecord(c, b, k, cc)
+			if cc != nil && err != nil && err != ErrUnknownKey {
+				// the connection may be unusable now
+				cc.Close()
+				cc = nil
+			}
+			if err == ErrUnknownKey {
+				// deleted since it was listed
+				stats.Skipped++
+				err = nil
+				continue
+			}
+			if err == nil {
+				err = enc.Encode(rec)
+			}
+			if err != nil {
+				stats.Errors++
+				if opts.OnError != nil {
+					opts.OnError(b, k, err)
+					err = nil
+				} else {
+					ctx.Cancel()
+				}
+				continue
+			}
+			stats.Keys++
+			stats.Siblings += len(rec.Siblings)
+		}
+		lerr := <-listErr
+		ctx.Cancel()
+		if err == nil {
+			err = lerr
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Returns the current vclock of bucket/key, or "" if it doesn't exist.
+func currentVclock(c Client, bucket, key string) (vclock string, err os.Error) {
+	rec, err := FetchRecord(c, bucket, key)
+	if err == ErrUnknownKey {
+		err = nil
+	}
+	vclock = rec.Vclock
+	return
+}
+
+func restoreHeader(s BackupSibling) (hdrs http.Header) {
+	hdrs = http.Header{}
+	for k, v := range s.Header {
+		if k != "Etag" && k != "Last-Modified" {
+			hdrs[k] = v
+		}
+	}
+	if hdrs.Get("Content-Type") == "" {
+		hdrs.Set("Content-Type", "application/octet-stream")
+	}
+	return
+}
+
+// Writes rec to c, according to policy.  skipped is true if the key already
+// existed and policy is RestoreSkipExisting.
+func RestoreRecord(c Client, rec BackupRecord, policy RestorePolicy) (skipped bool, err os.Error) {
+	var vclock string
+	switch policy {
+	case RestoreOverwrite:
+		vclock, err = currentVclock(c, rec.Bucket, rec.Key)
+	case RestoreKeepVclock:
+		vclock = rec.Vclock
+	}
+	if err != nil {
+		return
+	}
+	for i, s := range rec.Siblings {
+		hdrs := restoreHeader(s)
+		if vclock != "" {
+			hdrs.Set("X-Riak-Vclock", vclock)
+		}
+		if policy == RestoreSkipExisting && i == 0 {
+			hdrs.Set("If-None-Match", "*")
+		}
+		// on allow_mult buckets, writing the remaining siblings with the same
+		// vclock recreates them as siblings.
+		err = PutItem(c, rec.Bucket, rec.Key, s.Value, hdrs, nil, nil)
+		if err == ErrPreconditionFailed && i == 0 {
+			return true, nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Replays a backup written by Backup into c.
+func Restore(c Client, r io.Reader, opts RestoreOptions) (stats BackupStats, err os.Error) {
+	if opts.Gzip {
+		var gz *gzip.Decompressor
+		if gz, err = gzip.NewReader(r); err != nil {
+			return
+		}
+		defer gz.Close()
+		r = gz
+	}
+	dec := json.NewDecoder(r)
+	for {
+		var rec BackupRecord
+		if err = dec.Decode(&rec); err != nil {
+			if err == os.EOF {
+				err = nil
+			}
+			return
+		}
+		var skipped bool
+		skipped, err = RestoreRecord(c, rec, opts.Policy)
+		switch {
+		case err != nil:
+			stats.Errors++
+			if opts.OnError == nil {
+				return
+			}
+			opts.OnError(rec.Bucket, rec.Key, err)
+			err = nil
+		case skipped:
+			stats.Skipped++
+		default:
+			stats.Keys++
+			stats.Siblings += len(rec.Siblings)
+		}
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package riak
+
+import (
+	"bytes"
+	"http"
+	"json"
+	"testing"
+)
+
+func encodeRecords(recs ...BackupRecord) *bytes.Buffer {
+	buff := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(buff)
+	for _, r := range recs {
+		enc.Encode(r)
+	}
+	return buff
+}
+
+func TestBackupHeader(t *testing.T) {
+	out := backupHeader(http.Header{
+		"Content-Type":         []string{"text/plain"},
+		"X-Riak-Vclock":        []string{"abc"},
+		"X-Riak-Meta-Owner":    []string{"me"},
+		"X-Riak-Index-Age_int": []string{"5"},
+		"Link":                 []string{`</riak/b>; rel="up", </riak/b/k2>; riaktag="next"`},
+		"Server":               []string{"MochiWeb"},
+	})
+	fatalIf(t, out.Get("Content-Type") != "text/plain", "Lost Content-Type: %v", out)
+	fatalIf(t, out.Get("X-Riak-Meta-Owner") != "me", "Lost meta: %v", out)
+	fatalIf(t, out.Get("X-Riak-Index-Age_int") != "5", "Lost index: %v", out)
+	fatalIf(t, len(out["Link"]) != 1 || out.Get("Link") != `</riak/b/k2>; riaktag="next"`, "Wrong links: %v", out["Link"])
+	fatalIf(t, out.Get("X-Riak-Vclock") != "" || out.Get("Server") != "", "Kept too much: %v", out)
+}
+
+func TestFetchRecord(t *testing.T) {
+	c := replayClient(t, Interaction{
+		Request: RecordedRequest{Method: "GET", Path: "/riak/" + TESTING_BUCKET + "/k"},
+		Response: RecordedResponse{StatusCode: 200, Body: []byte("hello"), Header: http.Header{
+			"Content-Type":  []string{"text/plain"},
+			"X-Riak-Vclock": []string{"vc1"},
+		}},
+	})
+	rec, err := FetchRecord(c, TESTING_BUCKET, "k")
+	fatalIf(t, err != nil, "FetchRecord failed: %v", err)
+	fatalIf(t, rec.Vclock != "vc1", "Wrong vclock: %q", rec.Vclock)
+	fatalIf(t, len(rec.Siblings) != 1 || string(rec.Siblings[0].Value) != "hello", "Wrong siblings: %v", rec.Siblings)
+}
+
+func TestRestore(t *testing.T) {
+	path := "/riak/" + TESTING_BUCKET + "/"
+	c := replayClient(t,
+		Interaction{RecordedRequest{Method: "PUT", Path: path + "new", Body: []byte("one")}, RecordedResponse{StatusCode: 204}},
+		Interaction{RecordedRequest{Method: "PUT", Path: path + "new", Body: []byte("two")}, RecordedResponse{StatusCode: 204}},
+		Interaction{RecordedRequest{Method: "PUT", Path: path + "old", Body: []byte("three")}, RecordedResponse{StatusCode: 412}},
+	)
+	archive := encodeRecords(
+		BackupRecord{Bucket: TESTING_BUCKET, Key: "new", Vclock: "vc", Siblings: []BackupSibling{{Value: []byte("one")}, {Value: []byte("two")}}},
+		BackupRecord{Bucket: TESTING_BUCKET, Key: "old", Siblings: []BackupSibling{{Value: []byte("three")}}},
+	)
+	stats, err := Restore(c, archive, RestoreOptions{Policy: RestoreSkipExisting})
+	fatalIf(t, err != nil, "Restore failed: %v", err)
+	fatalIf(t, stats.Keys != 1 || stats.Siblings != 2 || stats.Skipped != 1 || stats.Errors != 0, "Wrong stats: %v", stats)
+	allReplayed(t, c)
+}
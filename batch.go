@@ -0,0 +1,189 @@
+package riak
+
+import (
+	"bytes"
+	"http"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// MultiGet and MultiPut fan a batch of requests out over a fixed number of
+// workers, each with a single connection that it reuses for all its requests
+// (and closes when the batch is finished).
+
+const DefaultBatchWorkers = 4
+
+type BucketKey struct {
+	Bucket string
+	Key    string
+}
+
+// An item to be written by MultiPut.  If Header is nil, the PutItem defaults apply.
+type Object struct {
+	Bucket string
+	Key    string
+	Value  []byte
+	Header http.Header
+}
+
+type BatchOptions struct {
+	// Number of concurrent requests (DefaultBatchWorkers if zero)
+	Workers int
+	// Passed to each GetItem/PutItem
+	Header http.Header
+	Parms  http.Values
+	// If non-nil, results are sent on Results as they complete (and the channel is
+	// closed when the batch is finished) rather than returned in input order.
+	Results chan<- BatchResult
+}
+
+type BatchResult struct {
+	// Position of the request in the input slice
+	Index  int
+	Bucket string
+	Key    string
+	// For MultiGet, the (fully buffered) response
+	Response *http.Response
+	Err      os.Error
+}
+
+type BatchStats struct {
+	Requests  int
+	Succeeded int
+	NotFound  int
+	Failed    int
+	// Total body bytes fetched (MultiGet) or stored (MultiPut)
+	Bytes int64
+	// Wall-clock time for the batch, in nanoseconds
+	Elapsed int64
+}
+
+func (self *BatchStats) add(r BatchResult, n int64) {
+	self.Requests++
+	switch r.Err {
+	case nil:
+		self.Succeeded++
+		self.Bytes += n
+	case ErrUnknownKey:
+		self.NotFound++
+	default:
+		self.Failed++
+	}
+}
+
+type batchDone struct {
+	result BatchResult
+	bytes  int64
+}
+
+// Returns a connection for a batch worker, or nil to have each request dial
+// its own (when replaying a cassette, or if the dial fails: the request will
+// report the error).
+func batchConn(c Client) (cc *http.ClientConn) {
+	if c.Cassette != nil && c.Cassette.Mode == CassetteReplay {
+		return
+	}
+	cc, err := dialHTTP(c.RootURL.Host, c.RootURL.Scheme)
+	if err != nil {
+		return nil
+	}
+	return
+}
+
+func runBatch(c Client, n int, opts BatchOptions, fn func(cc *http.ClientConn, i int) (BatchResult, int64)) (results []BatchResult, stats BatchStats) {
+	start := time.Nanoseconds()
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultBatchWorkers
+	}
+	if opts.Results == nil {
+		results = make([]BatchResult, n)
+	}
+	work := make(chan int)
+	done := make(chan batchDone)
+	for w := 0; w < workers; w++ {
+		go func() {
+			var cc *http.ClientConn
+			for i := range work {
+				if cc == nil {
+					cc = batchConn(c)
+				}
+				r, b := fn(cc, i)
+				if cc != nil && r.Err != nil && r.Err != ErrUnknownKey {
+					// the connection may be unusable now; the next request gets a new one
+					cc.Close()
+					cc = nil
+				}
+				done <- batchDone{r, b}
+			}
+			if cc != nil {
+				cc.Close()
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < n; i++ {
+			work <- i
+		}
+		close(work)
+	}()
+	for i := 0; i < n; i++ {
+		d := <-done
+		stats.add(d.result, d.bytes)
+		if opts.Results != nil {
+			opts.Results <- d.result
+		} else {
+			results[d.result.Index] = d.result
+		}
+	}
+	if opts.Results != nil {
+		close(opts.Results)
+	}
+	stats.Elapsed = time.Nanoseconds() - start
+	return
+}
+
+func copyHeader(in http.Header) (out http.Header) {
+	if in == nil {
+		return
+	}
+	out = http.Header{}
+	for k, v := range in {
+		out[k] = append([]string{}, v...)
+	}
+	return
+}
+
+// Fetches each of keys.  Response bodies are read in full before being returned.
+func MultiGet(c Client, keys []BucketKey, opts BatchOptions) ([]BatchResult, BatchStats) {
+	return runBatch(c, len(keys), opts, func(cc *http.ClientConn, i int) (r BatchResult, n int64) {
+		r = BatchResult{Index: i, Bucket: keys[i].Bucket, Key: keys[i].Key}
+		resp, err := GetItem(c, keys[i].Bucket, keys[i].Key, copyHeader(opts.Header), opts.Parms, cc)
+		if err == nil {
+			var body []byte
+			body, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+			n = int64(len(body))
+			r.Response = resp
+		}
+		r.Err = err
+		return
+	})
+}
+
+// Stores each of objs.  opts.Header is used for objects without a Header of their own.
+func MultiPut(c Client, objs []Object, opts BatchOptions) ([]BatchResult, BatchStats) {
+	return runBatch(c, len(objs), opts, func(cc *http.ClientConn, i int) (r BatchResult, n int64) {
+		o := objs[i]
+		r = BatchResult{Index: i, Bucket: o.Bucket, Key: o.Key}
+		hdrs := o.Header
+		if hdrs == nil {
+			hdrs = opts.Header
+		}
+		r.Err = PutItem(c, o.Bucket, o.Key, o.Value, copyHeader(hdrs), opts.Parms, cc)
+		n = int64(len(o.Value))
+		return
+	})
+}
@@ -0,0 +1,65 @@
+package riak
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func testBatchClient(t *testing.T) (c Client) {
+	var interactions []Interaction
+	for _, k := range []string{"a", "b", "c"} {
+		interactions = append(interactions, Interaction{
+			Request:  RecordedRequest{Method: "GET", Path: "/riak/" + TESTING_BUCKET + "/" + k},
+			Response: RecordedResponse{StatusCode: 200, Body: []byte("value " + k)},
+		})
+	}
+	interactions = append(interactions, Interaction{
+		Request:  RecordedRequest{Method: "GET", Path: "/riak/" + TESTING_BUCKET + "/missing"},
+		Response: RecordedResponse{StatusCode: 404},
+	})
+	return replayClient(t, interactions...)
+}
+
+func TestMultiGet(t *testing.T) {
+	c := testBatchClient(t)
+	keys := []BucketKey{{TESTING_BUCKET, "c"}, {TESTING_BUCKET, "missing"}, {TESTING_BUCKET, "a"}, {TESTING_BUCKET, "b"}}
+	results, stats := MultiGet(c, keys, BatchOptions{Workers: 2})
+	fatalIf(t, len(results) != 4, "Wrong number of results: %d", len(results))
+	for i, r := range results {
+		fatalIf(t, r.Index != i || r.Key != keys[i].Key, "Results out of order: %v", results)
+	}
+	fatalIf(t, results[1].Err != ErrUnknownKey, "Expected ErrUnknownKey, got %v", results[1].Err)
+	body, _ := ioutil.ReadAll(results[0].Response.Body)
+	fatalIf(t, string(body) != "value c", "Wrong body: %s", body)
+	fatalIf(t, stats.Requests != 4 || stats.Succeeded != 3 || stats.NotFound != 1 || stats.Failed != 0, "Wrong stats: %v", stats)
+	fatalIf(t, stats.Bytes != 21, "Wrong byte count: %d", stats.Bytes)
+}
+
+func TestMultiGetStreaming(t *testing.T) {
+	c := testBatchClient(t)
+	keys := []BucketKey{{TESTING_BUCKET, "a"}, {TESTING_BUCKET, "b"}, {TESTING_BUCKET, "c"}}
+	out := make(chan BatchResult)
+	seen := map[string]bool{}
+	done := make(chan int)
+	go func() {
+		for r := range out {
+			seen[r.Key] = r.Err == nil
+		}
+		done <- 1
+	}()
+	results, stats := MultiGet(c, keys, BatchOptions{Results: out})
+	<-done
+	fatalIf(t, results != nil, "Results shouldn't be returned when streaming")
+	fatalIf(t, len(seen) != 3 || !seen["a"] || !seen["b"] || !seen["c"], "Wrong streamed results: %v", seen)
+	fatalIf(t, stats.Succeeded != 3, "Wrong stats: %v", stats)
+}
+
+func TestMultiPutCanceled(t *testing.T) {
+	ctx := NewContext()
+	ctx.Cancel()
+	c := testClient(t).WithContext(ctx)
+	objs := []Object{{TESTING_BUCKET, "a", []byte("a"), nil}, {TESTING_BUCKET, "b", []byte("b"), nil}}
+	results, stats := MultiPut(c, objs, BatchOptions{})
+	fatalIf(t, results[0].Err != ErrCanceled || results[1].Err != ErrCanceled, "Expected ErrCanceled: %v", results)
+	fatalIf(t, stats.Failed != 2, "Wrong stats: %v", stats)
+}
@@ -0,0 +1,224 @@
+package riak
+
+import (
+	"bytes"
+	"container/list"
+	"http"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// A Cache keeps recently fetched values in memory.  With Client.Cache set,
+// GetItem calls without headers or parameters are served from the cache while
+// the entry is younger than TTL, and revalidated with a conditional GET (see
+// conditional.go) after that.  PutItem, PutItemReturnBody and DeleteItem
+// through a Client with the same Cache invalidate the key; writes from
+// anywhere else are only seen when the entry is revalidated.
+
+type CacheStats struct {
+	Hits int
+	// Stale entries the server said were still current
+	Revalidated int
+	Misses      int
+	Evictions   int
+	Entries     int
+	Bytes       int64
+}
+
+type cacheEntry struct {
+	id         string
+	header     http.Header
+	body       []byte
+	validators Validators
+	// When the entry was fetched or last revalidated, in nanoseconds; the only
+	// field changed after the entry is stored (under the cache's lock).
+	checked int64
+}
+
+// The zero value is an unbounded cache whose entries are always revalidated.
+type Cache struct {
+	// Bounds on the number of entries and the total size of their values; zero
+	// for no bound.
+	MaxEntries int
+	MaxBytes   int64
+	// Nanoseconds an entry is used for before it's revalidated.
+	TTL int64
+
+	lock sync.Mutex
+	// allocated on first use (see ready)
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   CacheStats
+	// Incremented by each Invalidate, so that a value fetched before a
+	// concurrent write isn't stored after it.
+	generation int64
+}
+
+func NewCache(maxEntries int, maxBytes int64, ttl int64) *Cache {
+	return &Cache{MaxEntries: maxEntries, MaxBytes: maxBytes, TTL: ttl}
+}
+
+// must be called with the lock held
+func (self *Cache) ready() {
+	if self.lru == nil {
+		self.lru = list.New()
+		self.entries = map[string]*list.Element{}
+	}
+}
+
+// Returns a copy of the client that reads through (and invalidates) cache.
+func (self Client) WithCache(cache *Cache) Client {
+	self.Cache = cache
+	return self
+}
+
+func cacheId(bucket, key string) string {
+	return bucket + "\x00" + key
+}
+
+func (self *Cache) Stats() (stats CacheStats) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.ready()
+	stats = self.stats
+	stats.Entries = self.lru.Len()
+	return
+}
+
+func (self *Cache) Invalidate(bucket, key string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.generation++
+	self.ready()
+	if e, ok := self.entries[cacheId(bucket, key)]; ok {
+		self.remove(e)
+	}
+}
+
+// must be called with the lock held
+func (self *Cache) remove(e *list.Element) {
+	ce := e.Value.(*cacheEntry)
+	self.lru.Remove(e)
+	self.entries[ce.id] = nil, false
+	self.stats.Bytes -= int64(len(ce.body))
+}
+
+// Also returns the entry's checked time and the generation to pass to store
+// or revalidated.
+func (self *Cache) lookup(id string) (ce *cacheEntry, checked, generation int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.ready()
+	if e, ok := self.entries[id]; ok {
+		self.lru.MoveToFront(e)
+		ce = e.Value.(*cacheEntry)
+		checked = ce.checked
+	}
+	return ce, checked, self.generation
+}
+
+// Does nothing if the cache was invalidated since generation.
+func (self *Cache) store(ce *cacheEntry, generation int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if generation != self.generation {
+		return
+	}
+	self.ready()
+	if e, ok := self.entries[ce.id]; ok {
+		self.remove(e)
+	}
+	if self.MaxBytes > 0 && int64(len(ce.body)) > self.MaxBytes {
+		return
+	}
+	self.entries[ce.id] = self.lru.PushFront(ce)
+	self.stats.Bytes += int64(len(ce.body))
+	for (self.MaxEntries > 0 && self.lru.Len() > self.MaxEntries) ||
+		(self.MaxBytes > 0 && self.stats.Bytes > self.MaxBytes) {
+		self.remove(self.lru.Back())
+		self.stats.Evictions++
+	}
+}
+
+func (self *Cache) count(field *int) {
+	self.lock.Lock()
+	*field++
+	self.lock.Unlock()
+}
+
+func (self *cacheEntry) response() *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    200,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        copyHeader(self.header),
+		Body:          ioutil.NopCloser(bytes.NewBuffer(self.body)),
+		ContentLength: int64(len(self.body)),
+	}
+}
+
+func (self *Cache) revalidated(ce *cacheEntry, generation int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.stats.Revalidated++
+	if generation == self.generation {
+		ce.checked = time.Nanoseconds()
+	}
+}
+
+// Caches the value in resp, returning a response to use in its place.
+func (self *Cache) keep(bucket, key string, resp *http.Response, generation int64) (*http.Response, os.Error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	ce := &cacheEntry{
+		id:         cacheId(bucket, key),
+		header:     copyHeader(resp.Header),
+		body:       body,
+		validators: ResponseValidators(resp),
+		checked:    time.Nanoseconds(),
+	}
+	self.store(ce, generation)
+	return ce.response(), nil
+}
+
+// c must not have a Cache set (or GetItem would call back here).
+func (self *Cache) get(c Client, bucket, key string, cc *http.ClientConn) (resp *http.Response, err os.Error) {
+	ce, checked, generation := self.lookup(cacheId(bucket, key))
+	if ce == nil {
+		self.count(&self.stats.Misses)
+		if resp, err = GetItem(c, bucket, key, nil, nil, cc); err == nil {
+			resp, err = self.keep(bucket, key, resp, generation)
+		}
+		return
+	}
+	if time.Nanoseconds()-checked < self.TTL {
+		self.count(&self.stats.Hits)
+		return ce.response(), nil
+	}
+	// with no validators, this is an ordinary GET
+	resp, err = GetItemIfChanged(c, bucket, key, ce.validators, nil, nil, cc)
+	switch err {
+	case ErrNotModified:
+		self.revalidated(ce, generation)
+		return ce.response(), nil
+	case nil:
+		self.count(&self.stats.Misses)
+		return self.keep(bucket, key, resp, generation)
+	case ErrUnknownKey:
+		self.Invalidate(bucket, key)
+	}
+	return
+}
+
+func (self Client) invalidate(bucket, key string) {
+	if self.Cache != nil {
+		self.Cache.Invalidate(bucket, key)
+	}
+}
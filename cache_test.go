@@ -0,0 +1,132 @@
+package riak
+
+import (
+	"bytes"
+	"http"
+	"io/ioutil"
+	"testing"
+)
+
+func cachedValue(t *testing.T, c Client, key string) string {
+	resp, err := GetItem(c, "b", key, nil, nil, nil)
+	fatalIf(t, err != nil, "GetItem(%s) failed: %v", key, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	fatalIf(t, err != nil, "Couldn't read %s: %v", key, err)
+	return string(body)
+}
+
+func TestCacheHit(t *testing.T) {
+	c := replayClient(t, Interaction{
+		RecordedRequest{Method: "GET", Path: "/riak/b/k"},
+		RecordedResponse{StatusCode: 200, Body: []byte("value"), Header: http.Header{"X-Riak-Vclock": []string{"abc"}}},
+	}).WithCache(NewCache(0, 0, 60e9))
+	for i := 0; i < 3; i++ {
+		v := cachedValue(t, c, "k")
+		fatalIf(t, v != "value", "Wrong value: %s", v)
+	}
+	stats := c.Cache.Stats()
+	fatalIf(t, stats.Misses != 1 || stats.Hits != 2, "Wrong stats: %+v", stats)
+	fatalIf(t, stats.Entries != 1 || stats.Bytes != 5, "Wrong size: %+v", stats)
+
+	resp, _ := GetItem(c, "b", "k", nil, nil, nil)
+	fatalIf(t, resp.Header.Get("X-Riak-Vclock") != "abc", "Vclock not cached: %v", resp.Header)
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	c := replayClient(t,
+		Interaction{
+			RecordedRequest{Method: "GET", Path: "/riak/b/k"},
+			RecordedResponse{StatusCode: 200, Body: []byte("value"), Header: http.Header{"Etag": []string{"\"e1\""}}},
+		},
+		Interaction{RecordedRequest{Method: "GET", Path: "/riak/b/k"}, RecordedResponse{StatusCode: 304}},
+		Interaction{
+			RecordedRequest{Method: "GET", Path: "/riak/b/k"},
+			RecordedResponse{StatusCode: 200, Body: []byte("newer"), Header: http.Header{"Etag": []string{"\"e2\""}}},
+		},
+		Interaction{RecordedRequest{Method: "GET", Path: "/riak/b/k"}, RecordedResponse{StatusCode: 404}},
+	).WithCache(NewCache(0, 0, 0))
+	fatalIf(t, cachedValue(t, c, "k") != "value", "Wrong first value")
+	fatalIf(t, cachedValue(t, c, "k") != "value", "Wrong revalidated value")
+	fatalIf(t, cachedValue(t, c, "k") != "newer", "Changed value not fetched")
+	_, err := GetItem(c, "b", "k", nil, nil, nil)
+	fatalIf(t, err != ErrUnknownKey, "Expected ErrUnknownKey, got %v", err)
+	stats := c.Cache.Stats()
+	fatalIf(t, stats.Revalidated != 1 || stats.Entries != 0, "Wrong stats: %+v", stats)
+}
+
+func TestCacheEviction(t *testing.T) {
+	var interactions []Interaction
+	for _, k := range []string{"a", "b", "c", "a"} {
+		interactions = append(interactions, Interaction{
+			RecordedRequest{Method: "GET", Path: "/riak/b/" + k},
+			RecordedResponse{StatusCode: 200, Body: []byte(k + k + k)},
+		})
+	}
+	c := replayClient(t, interactions...).WithCache(NewCache(2, 0, 60e9))
+	cachedValue(t, c, "a")
+	cachedValue(t, c, "b")
+	cachedValue(t, c, "c") // evicts a
+	cachedValue(t, c, "c")
+	cachedValue(t, c, "a") // fetched again, evicts b
+	stats := c.Cache.Stats()
+	fatalIf(t, stats.Evictions != 2 || stats.Entries != 2, "Wrong stats: %+v", stats)
+	fatalIf(t, stats.Hits != 1 || stats.Misses != 4, "Wrong stats: %+v", stats)
+
+	c = replayClient(t, interactions...).WithCache(NewCache(0, 5, 60e9))
+	cachedValue(t, c, "a")
+	cachedValue(t, c, "b") // 6 bytes in all; evicts a
+	stats = c.Cache.Stats()
+	fatalIf(t, stats.Entries != 1 || stats.Bytes != 3, "Wrong stats: %+v", stats)
+}
+
+func TestCacheInvalidatedByWrites(t *testing.T) {
+	c := replayClient(t,
+		Interaction{RecordedRequest{Method: "GET", Path: "/riak/b/k"}, RecordedResponse{StatusCode: 200, Body: []byte("old")}},
+		Interaction{RecordedRequest{Method: "PUT", Path: "/riak/b/k", Body: []byte("new")}, RecordedResponse{StatusCode: 204}},
+		Interaction{RecordedRequest{Method: "GET", Path: "/riak/b/k"}, RecordedResponse{StatusCode: 200, Body: []byte("new")}},
+		Interaction{RecordedRequest{Method: "DELETE", Path: "/riak/b/k"}, RecordedResponse{StatusCode: 204}},
+		Interaction{RecordedRequest{Method: "GET", Path: "/riak/b/k"}, RecordedResponse{StatusCode: 404}},
+	).WithCache(NewCache(0, 0, 60e9))
+	fatalIf(t, cachedValue(t, c, "k") != "old", "Wrong first value")
+	err := PutItem(c, "b", "k", []byte("new"), nil, nil, nil)
+	fatalIf(t, err != nil, "PutItem failed: %v", err)
+	fatalIf(t, cachedValue(t, c, "k") != "new", "Stale value after PutItem")
+	err = DeleteItem(c, "b", "k", nil, nil)
+	fatalIf(t, err != nil, "DeleteItem failed: %v", err)
+	_, err = GetItem(c, "b", "k", nil, nil, nil)
+	fatalIf(t, err != ErrUnknownKey, "Stale value after DeleteItem: %v", err)
+}
+
+func TestCacheStoreAfterInvalidate(t *testing.T) {
+	cache := NewCache(0, 0, 60e9)
+	// a GET that misses, then races with a write
+	_, _, generation := cache.lookup(cacheId("b", "k"))
+	cache.Invalidate("b", "k")
+	resp := &http.Response{Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewBufferString("old"))}
+	resp, err := cache.keep("b", "k", resp, generation)
+	fatalIf(t, err != nil, "keep failed: %v", err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	fatalIf(t, string(body) != "old", "Fetched value not returned: %s", body)
+	stats := cache.Stats()
+	fatalIf(t, stats.Entries != 0, "Value fetched before a write was cached: %+v", stats)
+
+	_, _, generation = cache.lookup(cacheId("b", "k"))
+	resp = &http.Response{Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewBufferString("new"))}
+	cache.keep("b", "k", resp, generation)
+	stats = cache.Stats()
+	fatalIf(t, stats.Entries != 1, "Value not cached: %+v", stats)
+}
+
+func TestCacheZeroValue(t *testing.T) {
+	c := replayClient(t, Interaction{
+		RecordedRequest{Method: "GET", Path: "/riak/b/k"}, RecordedResponse{StatusCode: 200, Body: []byte("value")},
+	}).WithCache(&Cache{MaxEntries: 10, TTL: 60e9})
+	for i := 0; i < 2; i++ {
+		v := cachedValue(t, c, "k")
+		fatalIf(t, v != "value", "Wrong value: %s", v)
+	}
+	stats := c.Cache.Stats()
+	fatalIf(t, stats.Misses != 1 || stats.Hits != 1 || stats.Entries != 1, "Wrong stats: %+v", stats)
+	(&Cache{}).Invalidate("b", "k")
+}
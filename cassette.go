@@ -0,0 +1,192 @@
+package riak
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"io/ioutil"
+	"json"
+	"os"
+	"sync"
+)
+
+// A Cassette records the requests a Client makes (and the responses it gets)
+// so they can later be replayed without a riak node.  Set Client.Cassette to use one.
+//
+// In replay mode, requests are matched on method, path, query parameters (in
+// any order) and body; each recorded interaction is used at most once, in the
+// order recorded.  A replay Cassette can also be built directly from a list of
+// Interactions.
+
+type CassetteMode int
+
+const (
+	CassetteRecord CassetteMode = iota
+	CassetteReplay
+)
+
+var ErrCassetteMismatch = os.NewError("No recorded interaction matches request")
+
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   []byte
+}
+
+type RecordedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+type Interaction struct {
+	Request  RecordedRequest
+	Response RecordedResponse
+}
+
+type Cassette struct {
+	Path         string
+	Mode         CassetteMode
+	Interactions []Interaction
+
+	lock sync.Mutex
+	// which Interactions have been replayed (allocated by replay)
+	used []bool
+}
+
+// In replay mode, the interactions recorded at path are loaded; in record mode
+// the cassette starts empty, and is written to path by Save.
+func NewCassette(path string, mode CassetteMode) (c *Cassette, err os.Error) {
+	c = &Cassette{Path: path, Mode: mode}
+	if mode == CassetteReplay {
+		var ob []byte
+		ob, err = ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(ob, &c.Interactions)
+		}
+	}
+	return
+}
+
+// Writes the recorded interactions to the cassette's Path.
+func (self *Cassette) Save() (err os.Error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ob, err := json.MarshalIndent(self.Interactions, "", "\t")
+	if err == nil {
+		err = ioutil.WriteFile(self.Path, ob, 0644)
+	}
+	return
+}
+
+// Reads (and replaces) the body of request so it can be both sent and recorded.
+func recordRequest(request *http.Request) (rr RecordedRequest, err os.Error) {
+	rr = RecordedRequest{
+		Method: request.Method,
+		Path:   request.URL.Path,
+		Query:  request.URL.RawQuery,
+		Header: copyHeader(request.Header),
+	}
+	if request.Body != nil {
+		rr.Body, err = ioutil.ReadAll(request.Body)
+		request.Body.Close()
+		request.Body = ioutil.NopCloser(bytes.NewBuffer(rr.Body))
+	}
+	return
+}
+
+// Query strings are compared as parameters, since Values.Encode doesn't order
+// them.
+func sameQuery(a, b string) bool {
+	if a == b {
+		return true
+	}
+	av, aerr := http.ParseQuery(a)
+	bv, berr := http.ParseQuery(b)
+	if aerr != nil || berr != nil || len(av) != len(bv) {
+		return false
+	}
+	for k, vs := range av {
+		if len(bv[k]) != len(vs) {
+			return false
+		}
+		for i, v := range vs {
+			if bv[k][i] != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (self RecordedRequest) matches(other RecordedRequest) bool {
+	return self.Method == other.Method && self.Path == other.Path &&
+		sameQuery(self.Query, other.Query) && bytes.Equal(self.Body, other.Body)
+}
+
+func (self RecordedResponse) response(request *http.Request) *http.Response {
+	hdr := http.Header{}
+	for k, v := range self.Header {
+		hdr[k] = v
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", self.StatusCode, http.StatusText(self.StatusCode)),
+		StatusCode:    self.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        hdr,
+		Body:          ioutil.NopCloser(bytes.NewBuffer(self.Body)),
+		ContentLength: int64(len(self.Body)),
+		Request:       request,
+	}
+}
+
+// Records through c, so its Context applies while recording too.
+func (self *Cassette) roundTrip(c Client, cc *http.ClientConn, request *http.Request) (resp *http.Response, err os.Error) {
+	rr, err := recordRequest(request)
+	if err != nil {
+		return
+	}
+	if self.Mode == CassetteReplay {
+		return self.replay(rr, request)
+	}
+	resp, err = c.roundTrip(cc, request)
+	if resp == nil || (err != nil && err != http.ErrPersistEOF) {
+		return
+	}
+	// the whole body is buffered (including streamed key lists and multipart
+	// sibling responses) so it can be recorded.
+	body, rerr := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if rerr != nil {
+		return nil, rerr
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	resp.ContentLength = int64(len(body))
+	self.lock.Lock()
+	self.Interactions = append(self.Interactions, Interaction{
+		Request:  rr,
+		Response: RecordedResponse{StatusCode: resp.StatusCode, Header: copyHeader(resp.Header), Body: body},
+	})
+	self.lock.Unlock()
+	return
+}
+
+func (self *Cassette) replay(rr RecordedRequest, request *http.Request) (resp *http.Response, err os.Error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for len(self.used) < len(self.Interactions) {
+		self.used = append(self.used, false)
+	}
+	for i := range self.Interactions {
+		if !self.used[i] && self.Interactions[i].Request.matches(rr) {
+			self.used[i] = true
+			return self.Interactions[i].Response.response(request), nil
+		}
+	}
+	err = os.NewError(fmt.Sprintf("%s: %s %s?%s", ErrCassetteMismatch, rr.Method, rr.Path, rr.Query))
+	return
+}
@@ -0,0 +1,71 @@
+package riak
+
+import (
+	"http"
+	"io/ioutil"
+	"testing"
+)
+
+// A client replaying interactions (see cassette.go).
+func replayClient(t *testing.T, interactions ...Interaction) (c Client) {
+	c = testClient(t)
+	c.Cassette = &Cassette{Mode: CassetteReplay, Interactions: interactions}
+	return
+}
+
+func allReplayed(t *testing.T, c Client) {
+	fatalIf(t, len(c.Cassette.used) != len(c.Cassette.Interactions), "Not all interactions replayed")
+	for i, used := range c.Cassette.used {
+		fatalIf(t, !used, "Interaction %d not replayed", i)
+	}
+}
+
+func testCassetteClient(t *testing.T) (c Client) {
+	return replayClient(t,
+		Interaction{
+			Request:  RecordedRequest{Method: "GET", Path: "/"},
+			Response: RecordedResponse{StatusCode: 200, Body: []byte("OK")},
+		},
+		Interaction{
+			Request: RecordedRequest{Method: "GET", Path: "/riak/" + TESTING_BUCKET + "/TestCassette"},
+			Response: RecordedResponse{
+				StatusCode: 200,
+				Header:     http.Header{"X-Riak-Vclock": []string{"vclock"}},
+				Body:       []byte("hello world"),
+			},
+		},
+	)
+}
+
+func TestCassetteReplay(t *testing.T) {
+	c := testCassetteClient(t)
+	err := Ping(c, nil)
+	fatalIf(t, err != nil, "Couldn't replay ping: %v", err)
+	resp, err := GetItem(c, TESTING_BUCKET, "TestCassette", nil, nil, nil)
+	fatalIf(t, err != nil, "Couldn't replay get: %v", err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	fatalIf(t, string(body) != "hello world", "Wrong body: %s", body)
+	fatalIf(t, resp.Header.Get("X-Riak-Vclock") != "vclock", "Wrong vclock: %s", resp.Header.Get("X-Riak-Vclock"))
+}
+
+func TestCassetteUnmatched(t *testing.T) {
+	c := testCassetteClient(t)
+	err := Ping(c, nil)
+	fatalIf(t, err != nil, "Couldn't replay ping: %v", err)
+	// each interaction is only used once
+	err = Ping(c, nil)
+	fatalIf(t, err == nil, "Expected an error replaying an unrecorded request")
+	_, err = GetItem(c, TESTING_BUCKET, "TestCassetteUnknown", nil, nil, nil)
+	fatalIf(t, err == nil, "Expected an error replaying an unrecorded request")
+}
+
+func TestCassetteQueryOrder(t *testing.T) {
+	c := replayClient(t, Interaction{
+		Request:  RecordedRequest{Method: "GET", Path: "/riak/" + TESTING_BUCKET + "/k", Query: "r=2&pr=1"},
+		Response: RecordedResponse{StatusCode: 200, Body: []byte("hello world")},
+	})
+	_, err := GetItem(c, TESTING_BUCKET, "k", nil, http.Values{"pr": []string{"1"}, "r": []string{"2"}}, nil)
+	fatalIf(t, err != nil, "Parameters in another order didn't match: %v", err)
+	allReplayed(t, c)
+	fatalIf(t, sameQuery("r=2", "r=3") || sameQuery("r=2", "r=2&w=1"), "Different queries matched")
+}
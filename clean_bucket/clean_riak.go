@@ -2,58 +2,292 @@ package main
 
 import "github.com/abneptis/riak"
 import (
+	"bufio"
+	"flag"
+	"fmt"
 	"http"
 	"log"
-	"flag"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
+var flag_url = flag.String("url", "http://localhost:8098/", "Riak root URL")
 var flag_threads = flag.Int("threads", 1, "Number of delete-threads")
 var flag_verbose = flag.Bool("verbose", false, "Verbose delete")
+var flag_dryrun = flag.Bool("dry-run", false, "List the keys that would be deleted, but don't delete them")
+var flag_prefix = flag.String("prefix", "", "Only delete keys with this prefix")
+var flag_match = flag.String("match", "", "Only delete keys matching this regular expression")
+var flag_rate = flag.Int("rate", 0, "Maximum deletes per second (0 for unlimited)")
+var flag_progress = flag.Int("progress", 10, "Seconds between progress reports (0 to disable)")
+var flag_checkpoint = flag.String("checkpoint", "", "File recording progress; re-running with the same file resumes where a previous run stopped")
 
+type stats struct {
+	lock    sync.Mutex
+	listed  int
+	matched int
+	deleted int
+	missing int
+	errors  int
+}
 
-func main(){
-	flag.Parse()
-	c,_  := riak.NewClient("", http.URL{Scheme:"http", Host: "localhost:8098", Path:"/"})
-	wg := &sync.WaitGroup{}
-	args := flag.Args()
-	if len(args) == 0 {
-		log.Fatalf("Usage: clean_bucket BUCKETNAME [...]")
+func (self *stats) inc(field *int) {
+	self.lock.Lock()
+	*field++
+	self.lock.Unlock()
+}
+
+func (self *stats) String() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return fmt.Sprintf("listed %d, matched %d, deleted %d, already gone %d, errors %d",
+		self.listed, self.matched, self.deleted, self.missing, self.errors)
+}
+
+// The checkpoint file is a log of lines, with the names quoted (as by
+// strconv.Quote) since they may contain spaces or newlines:
+//	D "<bucket>" "<key>"	(key deleted)
+//	B "<bucket>"		(bucket finished)
+type checkpoint struct {
+	lock    sync.Mutex
+	out     *os.File
+	deleted map[string]bool
+	buckets map[string]bool
+}
+
+func openCheckpoint(name string) (cp *checkpoint, err os.Error) {
+	cp = &checkpoint{deleted: map[string]bool{}, buckets: map[string]bool{}}
+	if name == "" {
+		return
 	}
+	if f, oerr := os.Open(name); oerr == nil {
+		r := bufio.NewReader(f)
+		for line, rerr := r.ReadString('\n'); rerr == nil; line, rerr = r.ReadString('\n') {
+			cp.load(strings.TrimRight(line, "\n"))
+		}
+		f.Close()
+	}
+	cp.out, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	return
+}
 
-	queue := [][2]string{}
+// Lines that don't parse (e.g. one cut short by a crash) are ignored.
+func (self *checkpoint) load(line string) {
+	if len(line) < 2 {
+		return
+	}
+	names, err := unquoteFields(line[2:])
+	switch {
+	case err != nil:
+	case line[0] == 'D' && len(names) == 2:
+		self.deleted[checkpointId(names[0], names[1])] = true
+	case line[0] == 'B' && len(names) == 1:
+		self.buckets[names[0]] = true
+	}
+}
 
-	for bi := range(args) {
-			ch := make(chan string, 32)
-			go func(b string){
-				for i := range(ch) {
-					queue = append(queue, [2]string{b, i}) 
-				}
-			}(args[bi])
-			err := riak.ListKeys(c, flag.Arg(0), ch, nil)
-			if err != nil{
-				log.Printf("Couldn't get bucket: %v", err)
-				return
+// Splits s into the space-separated quoted strings it's made of.
+func unquoteFields(s string) (fields []string, err os.Error) {
+	for s != "" {
+		if s[0] != '"' {
+			return nil, os.NewError("Unquoted field: " + s)
+		}
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
 			}
+			end++
+		}
+		if end >= len(s) {
+			return nil, os.NewError("Unterminated field: " + s)
+		}
+		var f string
+		if f, err = strconv.Unquote(s[:end+1]); err != nil {
+			return
+		}
+		fields = append(fields, f)
+		s = strings.TrimLeft(s[end+1:], " ")
+	}
+	return
+}
+
+func checkpointId(b, k string) string {
+	return b + "\x00" + k
+}
+
+func (self *checkpoint) record(s string) {
+	if self.out == nil {
+		return
 	}
-	thchan := make(chan int, *flag_threads)
-	for i := 0; i < *flag_threads; i ++ { thchan <- i }
+	self.lock.Lock()
+	self.out.WriteString(s + "\n")
+	self.lock.Unlock()
+}
+
+func (self *checkpoint) done(b, k string) bool {
+	return self.deleted[checkpointId(b, k)]
+}
+
+func (self *checkpoint) keyDeleted(b, k string) {
+	self.record("D " + strconv.Quote(b) + " " + strconv.Quote(k))
+}
 
-	for i := range(queue){
+func (self *checkpoint) bucketDone(b string) {
+	self.record("B " + strconv.Quote(b))
+	self.sync()
+}
+
+func (self *checkpoint) sync() {
+	if self.out != nil {
+		self.lock.Lock()
+		self.out.Sync()
+		self.lock.Unlock()
+	}
+}
+
+func (self *checkpoint) close() (err os.Error) {
+	if self.out == nil {
+		return
+	}
+	err = self.out.Sync()
+	if cerr := self.out.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+func selected(k string, re *regexp.Regexp) bool {
+	if !strings.HasPrefix(k, *flag_prefix) {
+		return false
+	}
+	return re == nil || re.MatchString(k)
+}
+
+// Streams the keys of b and deletes the selected ones as they arrive.
+func cleanBucket(c riak.Client, b string, re *regexp.Regexp, limiter <-chan int64, cp *checkpoint, st *stats) (err os.Error) {
+	keys := make(chan string, 256)
+	listErr := make(chan os.Error, 1)
+	go func() {
+		listErr <- riak.ListKeys(c, b, keys, nil)
+	}()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < *flag_threads; i++ {
 		wg.Add(1)
-		if *flag_verbose {
-			log.Printf("Calling delete on %s - %s", queue[i][0], queue[i][1])
-		}
-		go func(b, k string){
-			<- thchan
-			err := riak.DeleteItem(c, b, k, nil, nil)
-			thchan <- 1
-			if err != nil {
-				log.Printf("Error deleting %s - %s: %v", b,k,err)
+		go func() {
+			defer wg.Done()
+			for k := range keys {
+				st.inc(&st.listed)
+				if !selected(k, re) || cp.done(b, k) {
+					continue
+				}
+				st.inc(&st.matched)
+				if *flag_dryrun {
+					fmt.Printf("%s\t%s\n", b, k)
+					continue
+				}
+				if limiter != nil {
+					<-limiter
+				}
+				if *flag_verbose {
+					log.Printf("Calling delete on %s - %s", b, k)
+				}
+				switch err := riak.DeleteItem(c, b, k, nil, nil); err {
+				case nil:
+					st.inc(&st.deleted)
+					cp.keyDeleted(b, k)
+				case riak.ErrUnknownKey:
+					st.inc(&st.missing)
+					cp.keyDeleted(b, k)
+				default:
+					st.inc(&st.errors)
+					log.Printf("Error deleting %s - %s: %v", b, k, err)
+				}
 			}
-			wg.Done()
-		}(queue[i][0], queue[i][1])
+		}()
 	}
-	log.Printf("Waiting on children..") 
 	wg.Wait()
+	err = <-listErr
+	// keys that failed to delete must be retried on resume, so the bucket is
+	// only done if there were none.
+	if err == nil && !*flag_dryrun && st.errors == 0 {
+		cp.bucketDone(b)
+	}
+	return
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 || *flag_threads < 1 {
+		log.Fatalf("Usage: clean_bucket [flags] BUCKETNAME [...]")
+	}
+	u, err := http.ParseURL(*flag_url)
+	if err != nil {
+		log.Fatalf("Bad -url: %v", err)
+	}
+	c, err := riak.NewClient("", *u)
+	if err != nil {
+		log.Fatalf("Couldn't create client: %v", err)
+	}
+	var re *regexp.Regexp
+	if *flag_match != "" {
+		if re, err = regexp.Compile(*flag_match); err != nil {
+			log.Fatalf("Bad -match: %v", err)
+		}
+	}
+	cp, err := openCheckpoint(*flag_checkpoint)
+	if err != nil {
+		log.Fatalf("Couldn't open checkpoint: %v", err)
+	}
+	var limiter <-chan int64
+	if *flag_rate > 0 {
+		limiter = time.NewTicker(1e9 / int64(*flag_rate)).C
+	}
+
+	failed := false
+	for _, b := range args {
+		if cp.buckets[b] {
+			log.Printf("%s: already cleaned (per checkpoint)", b)
+			continue
+		}
+		st := &stats{}
+		start := time.Seconds()
+		quit := make(chan bool)
+		if *flag_progress > 0 {
+			go func(b string) {
+				t := time.NewTicker(int64(*flag_progress) * 1e9)
+				defer t.Stop()
+				for {
+					select {
+					case <-t.C:
+						log.Printf("%s: %s", b, st)
+					case <-quit:
+						return
+					}
+				}
+			}(b)
+		}
+		err = cleanBucket(c, b, re, limiter, cp, st)
+		close(quit)
+		if err != nil {
+			log.Printf("%s: couldn't list keys: %v", b, err)
+			failed = true
+		}
+		log.Printf("%s: finished in %ds: %s", b, time.Seconds()-start, st)
+		if st.errors > 0 {
+			failed = true
+		}
+	}
+	if err = cp.close(); err != nil {
+		log.Printf("Couldn't save checkpoint: %v", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/abneptis/riak/riaktest"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func fatalIf(t *testing.T, test bool, s string, v ...interface{}) {
+	if test {
+		t.Fatalf(s, v...)
+	}
+}
+
+func TestCheckpointNames(t *testing.T) {
+	f, err := ioutil.TempFile("", "checkpoint")
+	fatalIf(t, err != nil, "Couldn't create checkpoint: %v", err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	names := append([]string{"new\nline", `"quoted" \ slash`}, riaktest.TrickyNames...)
+	cp, err := openCheckpoint(f.Name())
+	fatalIf(t, err != nil, "openCheckpoint failed: %v", err)
+	for _, n := range names {
+		cp.keyDeleted(n, n)
+		cp.bucketDone(n)
+	}
+	cp.keyDeleted("a/b", "c")
+	err = cp.close()
+	fatalIf(t, err != nil, "close failed: %v", err)
+
+	cp, err = openCheckpoint(f.Name())
+	fatalIf(t, err != nil, "openCheckpoint failed: %v", err)
+	defer cp.close()
+	for _, n := range names {
+		fatalIf(t, !cp.done(n, n), "Key %q not reloaded", n)
+		fatalIf(t, !cp.buckets[n], "Bucket %q not reloaded", n)
+	}
+	fatalIf(t, cp.done("a", "b/c"), "a b/c confused with a/b c")
+	fatalIf(t, len(cp.deleted) != len(names)+1, "Wrong keys reloaded: %v", cp.deleted)
+}
+
+func TestCheckpointBadLines(t *testing.T) {
+	cp := &checkpoint{deleted: map[string]bool{}, buckets: map[string]bool{}}
+	for _, line := range []string{"", "D", `D "b"`, `D "b" "k`, `D b k`, `B "b" "k"`, `X "b"`} {
+		cp.load(line)
+	}
+	fatalIf(t, len(cp.deleted) != 0 || len(cp.buckets) != 0, "Bad lines loaded: %v %v", cp.deleted, cp.buckets)
+}
@@ -17,8 +17,20 @@ import (
 )
 
 type Client struct {
-	ClientId string
-	RootURL  http.URL
+	ClientId    string
+	RootURL     http.URL
+	// If non-nil, PutItem bodies are compressed (see compression.go)
+	Compression *Compression
+	// If non-nil, requests are recorded to (or replayed from) the cassette
+	Cassette    *Cassette
+	// If non-nil, operations are aborted when the context is canceled
+	Context     *Context
+	// If non-nil, the resource paths advertised by the server (see Discover)
+	Resources   Resources
+	// Whether to use /riak/B/K or /buckets/B/keys/K style URLs (see url_scheme.go)
+	URLScheme   URLScheme
+	// If non-nil, GetItem reads through the cache (see cache.go)
+	Cache       *Cache
 }
 
 var ErrUnknownKey = os.NewError("Unknown key")
@@ -26,6 +38,8 @@ var ErrPreconditionFailed = os.NewError("Precondition failed")
 var ErrServiceUnavailable = os.NewError("Service unavailable")
 var ErrBadRequest = os.NewError("Bad request")
 var ErrUnacceptable = os.NewError("That's unacceptable!")
+// From GetItem; use GetMultiItem to fetch the siblings.
+var ErrSiblings = os.NewError("Item has siblings")
 
 // If id is nil, os.Hostname().os.GetPid is used.  if os.Hostname returns an error,
 // this error will be returned.
@@ -50,22 +64,47 @@ func (self Client) request(method string, p string, hdrs http.Header, vals http.
 		URL:    &http.URL{Path: path.Join(self.RootURL.Path, p)},
 		Header: hdrs,
 	}
-	if method == "GET" {
-		req.URL.RawQuery = vals.Encode()
+	// quorum parameters (r, w, dw, rw) and returnbody are query parameters on writes too.
+	req.URL.RawQuery = vals.Encode()
+	// p (and so URL.Path) is already escaped (see escape.go); RawURL keeps
+	// the request line from escaping it again.
+	req.RawURL = req.URL.Path
+	if req.URL.RawQuery != "" {
+		req.RawURL += "?" + req.URL.RawQuery
 	}
 	// TODO: Deletes?
 	if method == "POST" || method == "PUT" {
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
 		req.Header.Set("X-Riak-ClientId", self.ClientId)
 	}
 	return
 }
 
+// Like the other resource paths, these are relative to RootURL (request adds it).
+// bucketPath is where the bucket properties are.
 func (self Client) bucketPath(name string) string {
-	return path.Join(self.RootURL.Path, "riak", name)
+	if self.bucketsURLs() {
+		return path.Join(self.resourcePath(ResourceProps), escapeSegment(name), "props")
+	}
+	return path.Join(self.resourcePath(ResourceBuckets), escapeSegment(name))
 }
 
+// With an empty name, keyPath is where the bucket's keys are listed.
 func (self Client) keyPath(bucket, name string) string {
-	return path.Join(self.RootURL.Path, "riak", bucket, name)
+	bucket, name = escapeSegment(bucket), escapeSegment(name)
+	if self.bucketsURLs() {
+		return path.Join(self.resourcePath(ResourceKeyList), bucket, "keys", name)
+	}
+	return path.Join(self.resourcePath(ResourceObject), bucket, name)
+}
+
+func (self Client) bucketListPath() string {
+	if self.bucketsURLs() {
+		return self.resourcePath(ResourceKeyList)
+	}
+	return self.resourcePath(ResourceBuckets)
 }
 
 
@@ -83,7 +122,11 @@ func getBucketRequest(c Client, name string, getprops, getkeys bool) (req *http.
 		qry.Set("keys", "true")
 	}
 
-	req = c.request("GET", c.bucketPath(name), hdrs, qry)
+	p := c.bucketPath(name)
+	if getkeys && !getprops && c.bucketsURLs() {
+		p = c.keyPath(name, "")
+	}
+	req = c.request("GET", p, hdrs, qry)
 	return
 }
 
@@ -97,10 +140,23 @@ type BucketDetails struct {
 // tweaked your riak install.  See ListKeys to use the streaming interface.
 // ListKeys is generally discouraged in production (see http://wiki.basho.com/HTTP-List-Keys.html)
 func GetBucket(c Client, name string, getprops, getkeys bool, cc *http.ClientConn) (br BucketDetails, err os.Error) {
+	// the /buckets URLs have separate resources for properties and keys
+	if getprops && getkeys && c.bucketsURLs() {
+		if br, err = GetBucket(c, name, true, false, cc); err == nil {
+			var kr BucketDetails
+			kr, err = GetBucket(c, name, false, true, cc)
+			br.Keys = kr.Keys
+		}
+		return
+	}
 	req := getBucketRequest(c, name, getprops, getkeys)
-	err = dispatchRequest(cc, req, map[int]func(*http.Response) os.Error{
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
 		200: func(r *http.Response) (err os.Error) {
+			defer r.Body.Close()
 			err = json.NewDecoder(r.Body).Decode(&br)
+			for i := range br.Keys {
+				br.Keys[i] = unescapeName(br.Keys[i])
+			}
 			return
 		},
 		-1: failf("Server refused enumeration"),
@@ -142,16 +198,21 @@ func okf(*http.Response) os.Error {
 	return nil
 }
 
+// Like okf, for responses with a body nobody wants.
+func closef(resp *http.Response) os.Error {
+	return resp.Body.Close()
+}
+
 func pingRequest(c Client) (req *http.Request) {
 	// note there's no /riak/ on a PING
-	req = c.request("GET", "/", nil, nil)
+	req = c.request("GET", c.resourcePath(ResourcePing), nil, nil)
 	return
 }
 
 func SetBucket(c Client, name string, props Properties, cc *http.ClientConn) (err os.Error) {
 	req, err := setBucketRequest(c, name, props)
 	if err == nil {
-		err = dispatchRequest(cc, req, map[int]func(*http.Response) os.Error{
+		err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
 			204: func(*http.Response) os.Error { return nil },
 			-1:  failf("Server refused creation"),
 		})
@@ -160,6 +221,14 @@ func SetBucket(c Client, name string, props Properties, cc *http.ClientConn) (er
 }
 
 func dialHTTP(hoststring string, scheme string) (cc *http.ClientConn, err os.Error) {
+	c, err := dialConn(hoststring, scheme)
+	if err == nil {
+		cc = http.NewClientConn(c, nil)
+	}
+	return
+}
+
+func dialConn(hoststring string, scheme string) (c net.Conn, err os.Error) {
 	host, port, err := net.SplitHostPort(hoststring)
 	if err != nil {
 		return
@@ -179,23 +248,19 @@ func dialHTTP(hoststring string, scheme string) (cc *http.ClientConn, err os.Err
 	if err != nil {
 		return
 	}
-	var c net.Conn
 	switch scheme {
 	case "https":
 		c, err = tls.Dial("tcp", host+":"+port, nil)
 	default:
 		c, err = net.Dial("tcp", host+":"+port)
 	}
-	if err == nil {
-		cc = http.NewClientConn(c, nil)
-	}
 	return
 }
 
 func Ping(c Client, cc *http.ClientConn) (err os.Error) {
 	// note there's no /riak/ on a PING
 	req := pingRequest(c)
-	err = dispatchRequest(cc, req, map[int]func(*http.Response) os.Error{
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
 		200: okf,
 		-1:  failf("Unexpected response from ping"),
 	})
@@ -205,7 +270,7 @@ func Ping(c Client, cc *http.ClientConn) (err os.Error) {
 
 func listBucketsRequest(c Client) (req *http.Request) {
 	reqvals := http.Values{"buckets": []string{"true"}}
-	req = c.request("GET", "riak", nil, reqvals)
+	req = c.request("GET", c.bucketListPath(), nil, reqvals)
 	return
 }
 
@@ -215,12 +280,16 @@ type listResponse struct {
 
 func ListBuckets(c Client, cc *http.ClientConn) (names []string, err os.Error) {
 	req := listBucketsRequest(c)
-	err = dispatchRequest(cc, req, map[int]func(*http.Response) os.Error{
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
 		200: func(r *http.Response) (err os.Error) {
+			defer r.Body.Close()
 			lr := listResponse{}
 			err = json.NewDecoder(r.Body).Decode(&lr)
 			if err == nil {
 				names = lr.Buckets
+				for i := range names {
+					names[i] = unescapeName(names[i])
+				}
 			}
 			return
 		},
@@ -230,27 +299,37 @@ func ListBuckets(c Client, cc *http.ClientConn) (names []string, err os.Error) {
 }
 
 // ListKeys is generally discouraged in production (see http://wiki.basho.com/HTTP-List-Keys.html)
+// outch is closed when ListKeys returns.
 func ListKeys(c Client, b string, outch chan<- string, cc *http.ClientConn)(err os.Error){
+	defer close(outch)
 	resp, err := GetItem(c, b, "",http.Header{"Accept":[]string{"application/json"}}, 
 									http.Values{
 										"keys":[]string{"stream"},
 										"props":[]string{"false"},
 									}, cc)
-	if resp != nil && resp.StatusCode == 200 {
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 200 {
 		
 
 		dec := json.NewDecoder(resp.Body)
 		bi := BucketDetails{}
 		for err = dec.Decode(&bi); err == nil ; err = dec.Decode(&bi){
 			for i := range(bi.Keys){
-				outch <- bi.Keys[i]
+				if err = c.sendKey(outch, unescapeName(bi.Keys[i])); err != nil {
+					return
+				}
 			}
 		}
 		if err == os.EOF { err = nil }
 	} else {
 		return debugFailf(os.Stdout, true, "Unexpected response: %s", resp.Status)(resp)
 	}
-	close(outch)
+	if cerr := c.canceled(); cerr != nil {
+		err = cerr
+	}
 	return
 
 }
@@ -262,14 +341,24 @@ func getItemRequest(c Client, bucket, key string, hdrs http.Header, parms http.V
 }
 
 func GetItem(c Client, bucket, key string, hdrs http.Header, parms http.Values, cc *http.ClientConn) (resp *http.Response, err os.Error) {
+	if c.Cache != nil && len(hdrs) == 0 && len(parms) == 0 {
+		cache := c.Cache
+		c.Cache = nil
+		return cache.get(c, bucket, key, cc)
+	}
 	req := getItemRequest(c, bucket, key, hdrs, parms)
-	err = dispatchRequest(cc, req, map[int]func(*http.Response) os.Error{
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
 		-1:  debugFailf(os.Stdout, true, "GetItem failed"+req.URL.String()),
 		404: func(*http.Response) os.Error { return ErrUnknownKey },
+		// only with If-None-Match or If-Modified-Since in hdrs (see conditional.go)
+		304: func(*http.Response) os.Error { return ErrNotModified },
+		300: func(*http.Response) os.Error { return ErrSiblings },
 		200: func(rresp *http.Response) (err os.Error) {
 			// This doesn't actually happen unless someone else has resolved the item for us, but we'll
 			// take it if it happens.
+			keepBody(rresp)
 			resp = rresp
+			err = decompressResponse(resp)
 			return
 		},
 	})
@@ -290,52 +379,77 @@ func getMultiItemRequest(c Client, bucket, key string, hdrs http.Header, parms h
 }
 
 
+// Sends a single (200) object response on respch.
+func (self Client) sendObject(respch chan<- *http.Response, resp *http.Response) (err os.Error) {
+	keepBody(resp)
+	err = decompressResponse(resp)
+	if err == nil {
+		err = self.sendResponse(respch, resp)
+	}
+	return
+}
+
+// Sends each sibling in a 300 multipart/mixed response on respch.
+func (self Client) sendSiblings(respch chan<- *http.Response, resp *http.Response) (err os.Error) {
+	defer resp.Body.Close()
+	mtype, mparms := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mtype != "multipart/mixed" {
+		return debugFailf(os.Stdout, true, "Server gave us a 300, but not a multipart/mixed message\t"+mtype)(resp)
+	}
+	if err == nil && mparms["boundary"] == "" {
+		err = os.NewError("No boundry name found in content-type")
+	}
+	if err == nil {
+		var body io.Reader = resp.Body
+		if resp.ContentLength >= 0 {
+			body = io.LimitReader(resp.Body, resp.ContentLength)
+		}
+		mpart := multipart.NewReader(body, mparms["boundary"])
+		var part *multipart.Part
+		for part, err = mpart.NextPart(); err == nil; part, err = mpart.NextPart() {
+			// if we don't swallow the reader now, the caller may not get their bits (multipart closes when we call NextPart()).
+			buff := bytes.NewBuffer(nil)
+			n, _ := buff.ReadFrom(part)
+
+			rr := &http.Response{
+				Body:          ioutil.NopCloser(buff),
+				Header:        http.Header(part.Header),
+				ContentLength: int64(n),
+			}
+			// Riak doesn't include a vclock in the sub-headers, and readers may want to use them.
+			rr.Header.Set("X-Riak-Vclock", resp.Header.Get("X-Riak-Vclock"))
+			if err = decompressResponse(rr); err != nil {
+				break
+			}
+			if err = self.sendResponse(respch, rr); err != nil {
+				break
+			}
+		}
+		if err == os.EOF {
+			err = nil
+		}
+	}
+	return
+}
+
 // for hdrs, see 'http://wiki.basho.com/HTTP-Fetch-Object.html'
 // NB: If no accept is set, we will choose multipart/mixed.
 func GetMultiItem(c Client, bucket, key string, hdrs http.Header, parms http.Values, respch chan<- *http.Response, cc *http.ClientConn) (err os.Error) {
 	req := getMultiItemRequest(c, bucket, key, hdrs, parms)
-	err = dispatchRequest(cc, req, map[int]func(*http.Response) os.Error{
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
 		-1:  debugFailf(os.Stdout, true, "GetMultiItem failed"),
 		400: func(*http.Response) os.Error { return ErrBadRequest },
+		304: func(*http.Response) os.Error { return ErrNotModified },
 		404: func(*http.Response) os.Error { return ErrUnknownKey },
 		406: func(*http.Response) os.Error { return ErrUnacceptable },
 		503: func(*http.Response) os.Error { return ErrServiceUnavailable },
-		200: func(resp *http.Response) (err os.Error) {
+		200: func(resp *http.Response) os.Error {
 			// This doesn't actually happen unless someone else has resolved the item for us, but we'll
 			// take it if it happens.
-			respch <- resp
-			return
+			return c.sendObject(respch, resp)
 		},
-		300: func(resp *http.Response) (err os.Error) {
-			mtype, mparms := mime.ParseMediaType(resp.Header.Get("Content-Type"))
-			if mtype != "multipart/mixed" {
-				return debugFailf(os.Stdout, true, "Server gave us a 300, but not a multipart/mixed message\t"+mtype)(resp)
-			}
-			if err == nil && mparms["boundary"] == "" {
-				err = os.NewError("No boundry name found in content-type")
-			}
-			if err == nil {
-				mpart := multipart.NewReader(io.LimitReader(resp.Body, resp.ContentLength), mparms["boundary"])
-				var part *multipart.Part
-				for part, err = mpart.NextPart(); err == nil; part, err = mpart.NextPart() {
-					// if we don't swallow the reader now, the caller may not get their bits (multipart closes when we call NextPart()).
-					buff := bytes.NewBuffer(nil)
-					n, _ := buff.ReadFrom(part)
-
-					rr := &http.Response{
-						Body:          ioutil.NopCloser(buff),
-						Header:        http.Header(part.Header),
-						ContentLength: int64(n),
-					}
-					// Riak doesn't include a vclock in the sub-headers, and readers may want to use them.
-					rr.Header.Set("X-Riak-Vclock", resp.Header.Get("X-Riak-Vclock"))
-					respch <- rr
-				}
-				if err == os.EOF {
-					err = nil
-				}
-			}
-			return
+		300: func(resp *http.Response) os.Error {
+			return c.sendSiblings(respch, resp)
 		},
 	})
 	close(respch)
@@ -348,6 +462,7 @@ func putItemRequest(c Client, bucket, key string, body []byte, hdrs http.Header,
 	if hdrs == nil {
 		hdrs = http.Header{"Content-Type": []string{"application/binary"}}
 	}
+	body, hdrs = c.compressBody(body, hdrs)
 	req = c.request("PUT", c.keyPath(bucket, key), hdrs, parms)
 	req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 	req.ContentLength = int64(len(body))
@@ -356,11 +471,57 @@ func putItemRequest(c Client, bucket, key string, body []byte, hdrs http.Header,
 
 // for hdrs, you can include any header (see 'http://wiki.basho.com/HTTP-Fetch-Object.html' for riak specific headers)
 func PutItem(c Client, bucket, key string, body []byte, hdrs http.Header, parms http.Values, cc *http.ClientConn) (err os.Error) {
+	defer c.invalidate(bucket, key)
 	req := putItemRequest(c, bucket, key, body, hdrs, parms)
-	err = dispatchRequest(cc, req, map[int]func(*http.Response) os.Error{
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
 		-1: debugFailf(os.Stdout, true, "PutItem	failed:"+req.URL.String()),
 		412: func(*http.Response) os.Error { return ErrPreconditionFailed },
 		204: okf,
+		// returnbody=true in parms; use PutItemReturnBody to get the body.
+		200: closef,
+		300: closef,
+	})
+	return
+}
+
+// As PutItem, but with returnbody=true: the stored object is sent on respch (or,
+// if the write created siblings, each of them is), with its new vclock.  respch
+// is closed when PutItemReturnBody returns.
+// NB: If no accept is set, we will choose multipart/mixed (or the value itself
+// if there are no siblings).
+// The caller's hdrs and parms are left as they were.
+func putItemReturnBodyRequest(c Client, bucket, key string, body []byte, hdrs http.Header, parms http.Values) (req *http.Request) {
+	hdrs = copyHeader(hdrs)
+	if hdrs == nil {
+		hdrs = http.Header{"Content-Type": []string{"application/binary"}}
+	}
+	if hdrs.Get("Accept") == "" {
+		hdrs.Set("Accept", "multipart/mixed, */*;q=0.5")
+	}
+	vals := http.Values{}
+	for k, v := range parms {
+		vals[k] = v
+	}
+	vals.Set("returnbody", "true")
+	req = putItemRequest(c, bucket, key, body, hdrs, vals)
+	return
+}
+
+func PutItemReturnBody(c Client, bucket, key string, body []byte, hdrs http.Header, parms http.Values, respch chan<- *http.Response, cc *http.ClientConn) (err os.Error) {
+	defer c.invalidate(bucket, key)
+	defer close(respch)
+	req := putItemReturnBodyRequest(c, bucket, key, body, hdrs, parms)
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+		-1:  debugFailf(os.Stdout, true, "PutItemReturnBody failed:"+req.URL.String()),
+		400: func(*http.Response) os.Error { return ErrBadRequest },
+		412: func(*http.Response) os.Error { return ErrPreconditionFailed },
+		503: func(*http.Response) os.Error { return ErrServiceUnavailable },
+		200: func(resp *http.Response) os.Error {
+			return c.sendObject(respch, resp)
+		},
+		300: func(resp *http.Response) os.Error {
+			return c.sendSiblings(respch, resp)
+		},
 	})
 	return
 }
@@ -372,8 +533,9 @@ func deleteItemRequest(c Client, bucket, key string, parms http.Values) (req *ht
 
 
 func DeleteItem(c Client, bucket, key string, parms http.Values, cc *http.ClientConn) (err os.Error) {
+	defer c.invalidate(bucket, key)
 	req := deleteItemRequest(c, bucket, key, parms)
-	err = dispatchRequest(cc, req, map[int]func(*http.Response) os.Error{
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
 		-1:  debugFailf(os.Stdout, true, "DeleteItem failed"),
 		404: func(*http.Response) os.Error { return ErrUnknownKey },
 		204: okf,
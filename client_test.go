@@ -3,6 +3,10 @@ package riak
 import "testing"
 import "path"
 import "json"
+import "http"
+
+// riaktest.MemoryKV is the other implementation
+var _ KV = Client{}
 
 func TestPingRequest(t *testing.T) {
 	c := testClient(t)
@@ -116,3 +120,34 @@ func TestGetSingleItemRequest(t *testing.T) {
 	fatalIf(t, req.URL.RawQuery != "", "Unexpected raw-query: %s", req.URL.RawQuery)
 	fatalIf(t, req.Header.Get("Content-Type") == "", "Content-type must be set: (got none)")
 }
+
+func TestPutItemRequestQuorum(t *testing.T) {
+	c := testClient(t)
+	req := putItemRequest(c, TESTING_BUCKET, "TestPutItemRequestQuorum", []byte("hello world"), nil, http.Values{"w": []string{"all"}})
+	fatalIf(t, req.URL.RawQuery != "w=all", "Quorum parameter not passed: %s", req.URL.RawQuery)
+	req = deleteItemRequest(c, TESTING_BUCKET, "TestPutItemRequestQuorum", http.Values{"rw": []string{"2"}})
+	fatalIf(t, req.URL.RawQuery != "rw=2", "Quorum parameter not passed: %s", req.URL.RawQuery)
+}
+
+func TestWriteRequest(t *testing.T) {
+	c := testClient(t)
+	req := c.request("POST", "riak/"+TESTING_BUCKET, nil, http.Values{"returnbody": []string{"true"}})
+	fatalIf(t, req.Header == nil, "No headers created for a write")
+	fatalIf(t, req.Header.Get("X-Riak-ClientId") != c.ClientId, "Client id not set: %v", req.Header)
+	fatalIf(t, req.URL.RawQuery != "returnbody=true", "Query parameter not passed: %s", req.URL.RawQuery)
+}
+
+func TestPutItemReturnBodyRequest(t *testing.T) {
+	c := testClient(t)
+	hdrs := http.Header{"Content-Type": []string{"text/plain"}}
+	parms := http.Values{"w": []string{"all"}}
+	req := putItemReturnBodyRequest(c, TESTING_BUCKET, "TestPutItemReturnBodyRequest", []byte("hello world"), hdrs, parms)
+	fatalIf(t, req.Method != "PUT", "Unexpected method: %s", req.Method)
+	fatalIf(t, req.FormValue("returnbody") != "true" || req.FormValue("w") != "all", "Wrong query: %s", req.URL.RawQuery)
+	fatalIf(t, req.Header.Get("Accept") == "", "Accept not set: %v", req.Header)
+	fatalIf(t, len(hdrs) != 1 || hdrs.Get("Accept") != "", "Caller's headers modified: %v", hdrs)
+	fatalIf(t, len(parms) != 1, "Caller's parameters modified: %v", parms)
+
+	req = putItemReturnBodyRequest(c, TESTING_BUCKET, "TestPutItemReturnBodyRequest", []byte("hello world"), nil, nil)
+	fatalIf(t, req.Header.Get("Content-Type") == "", "Content-type must be set: (got none)")
+}
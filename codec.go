@@ -0,0 +1,154 @@
+package riak
+
+import (
+	"bytes"
+	"gob"
+	"http"
+	"io/ioutil"
+	"json"
+	"mime"
+	"os"
+	"sync"
+)
+
+// A Codec converts go values to and from the stored representation of
+// a single content-type.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, os.Error)
+	Unmarshal(data []byte, v interface{}) os.Error
+}
+
+var ErrUnknownContentType = os.NewError("No codec registered for content-type")
+var ErrUnsupportedValue = os.NewError("Value not supported by codec")
+
+var codecLock sync.RWMutex
+var codecs = map[string]Codec{
+	"application/json":         JSONCodec{},
+	"application/x-gob":        GobCodec{},
+	"application/octet-stream": RawCodec{},
+	"application/binary":       RawCodec{},
+	"text/plain":               RawCodec{},
+}
+
+// Registers (or replaces) the codec used for ctype.  Any parameters
+// (e.g., charset) in ctype are ignored.
+func RegisterCodec(ctype string, c Codec) {
+	mtype, _ := mime.ParseMediaType(ctype)
+	codecLock.Lock()
+	codecs[mtype] = c
+	codecLock.Unlock()
+}
+
+// Returns the codec registered for ctype, or ErrUnknownContentType.
+func CodecFor(ctype string) (c Codec, err os.Error) {
+	mtype, _ := mime.ParseMediaType(ctype)
+	codecLock.RLock()
+	c, ok := codecs[mtype]
+	codecLock.RUnlock()
+	if !ok {
+		err = ErrUnknownContentType
+	}
+	return
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, os.Error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) os.Error {
+	return json.Unmarshal(data, v)
+}
+
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) (out []byte, err os.Error) {
+	buff := bytes.NewBuffer(nil)
+	err = gob.NewEncoder(buff).Encode(v)
+	if err == nil {
+		out = buff.Bytes()
+	}
+	return
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) os.Error {
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(v)
+}
+
+// RawCodec passes bytes through unmodified; it accepts []byte and string
+// values (and pointers to them when unmarshalling).
+type RawCodec struct{}
+
+func (RawCodec) Marshal(v interface{}) (out []byte, err os.Error) {
+	switch t := v.(type) {
+	case []byte:
+		out = t
+	case string:
+		out = []byte(t)
+	default:
+		err = ErrUnsupportedValue
+	}
+	return
+}
+
+func (RawCodec) Unmarshal(data []byte, v interface{}) (err os.Error) {
+	switch t := v.(type) {
+	case *[]byte:
+		*t = data
+	case *string:
+		*t = string(data)
+	default:
+		err = ErrUnsupportedValue
+	}
+	return
+}
+
+// Marshals v with the codec registered for ctype and stores it.  If ctype is
+// empty, application/json is used.  Any Content-Type in hdrs is replaced.
+func PutValue(c Client, bucket, key string, ctype string, v interface{}, hdrs http.Header, parms http.Values, cc *http.ClientConn) (err os.Error) {
+	if ctype == "" {
+		ctype = "application/json"
+	}
+	codec, err := CodecFor(ctype)
+	if err != nil {
+		return
+	}
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return
+	}
+	hdrs = copyHeader(hdrs)
+	if hdrs == nil {
+		hdrs = http.Header{}
+	}
+	hdrs.Set("Content-Type", ctype)
+	err = PutItem(c, bucket, key, body, hdrs, parms, cc)
+	return
+}
+
+// Fetches the item and decodes it into v using the codec registered for the
+// Content-Type the server returned.
+func GetInto(c Client, bucket, key string, v interface{}, hdrs http.Header, parms http.Values, cc *http.ClientConn) (err os.Error) {
+	resp, err := GetItem(c, bucket, key, hdrs, parms, cc)
+	if err != nil {
+		return
+	}
+	err = DecodeResponse(resp, v)
+	return
+}
+
+// Decodes (and closes) the body of resp into v, choosing the codec from
+// the response Content-Type.  Useful for the siblings returned by GetMultiItem.
+func DecodeResponse(resp *http.Response, v interface{}) (err os.Error) {
+	defer resp.Body.Close()
+	codec, err := CodecFor(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		err = codec.Unmarshal(body, v)
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package riak
+
+import (
+	"bytes"
+	"http"
+	"io/ioutil"
+	"testing"
+)
+
+type codecTestValue struct {
+	Name  string
+	Count int
+}
+
+func TestCodecLookup(t *testing.T) {
+	c, err := CodecFor("application/json; charset=utf-8")
+	fatalIf(t, err != nil, "Couldn't find json codec: %v", err)
+	_, ok := c.(JSONCodec)
+	fatalIf(t, !ok, "Got the wrong codec for json: %T", c)
+	_, err = CodecFor("application/x-unknown")
+	fatalIf(t, err != ErrUnknownContentType, "Expected ErrUnknownContentType, got %v", err)
+}
+
+func TestPutValueKeepsHeaders(t *testing.T) {
+	c := replayClient(t, Interaction{
+		RecordedRequest{Method: "PUT", Path: "/riak/b/k", Body: []byte(`{"Name":"x","Count":1}`)}, RecordedResponse{StatusCode: 204},
+	})
+	hdrs := http.Header{"X-Riak-Meta-A": []string{"1"}}
+	err := PutValue(c, "b", "k", "", codecTestValue{"x", 1}, hdrs, nil, nil)
+	fatalIf(t, err != nil, "PutValue failed: %v", err)
+	fatalIf(t, hdrs.Get("Content-Type") != "", "Caller's headers modified: %v", hdrs)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, ctype := range []string{"application/json", "application/x-gob"} {
+		c, err := CodecFor(ctype)
+		fatalIf(t, err != nil, "Couldn't find codec for %s: %v", ctype, err)
+		in := codecTestValue{"hello", 3}
+		out := codecTestValue{}
+		b, err := c.Marshal(in)
+		fatalIf(t, err != nil, "Couldn't marshal with %s: %v", ctype, err)
+		err = c.Unmarshal(b, &out)
+		fatalIf(t, err != nil, "Couldn't unmarshal with %s: %v", ctype, err)
+		fatalIf(t, in.Name != out.Name || in.Count != out.Count, "Round trip through %s failed: %v != %v", ctype, in, out)
+	}
+}
+
+func TestRawCodec(t *testing.T) {
+	b, err := RawCodec{}.Marshal("hello world")
+	fatalIf(t, err != nil, "Couldn't marshal string: %v", err)
+	var s string
+	err = RawCodec{}.Unmarshal(b, &s)
+	fatalIf(t, err != nil || s != "hello world", "Bad raw unmarshal: %v (%s)", err, s)
+	_, err = RawCodec{}.Marshal(3)
+	fatalIf(t, err != ErrUnsupportedValue, "Expected ErrUnsupportedValue, got %v", err)
+}
+
+func TestDecodeResponse(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewBufferString(`{"Name":"hello","Count":3}`)),
+	}
+	out := codecTestValue{}
+	err := DecodeResponse(resp, &out)
+	fatalIf(t, err != nil, "Couldn't decode response: %v", err)
+	fatalIf(t, out.Name != "hello" || out.Count != 3, "Decoded wrong value: %v", out)
+}
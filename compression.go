@@ -0,0 +1,125 @@
+package riak
+
+import (
+	"bytes"
+	"compress/gzip"
+	"http"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// A Compressor implements a single content-encoding (e.g., gzip).
+//
+// Only gzip is registered by default: there is no snappy in the standard
+// library, so snappy (e.g., via snappy-go) is left to RegisterCompressor.
+type Compressor interface {
+	Encoding() string
+	Compress([]byte) ([]byte, os.Error)
+	Decompress([]byte) ([]byte, os.Error)
+}
+
+// Compression settings for a Client.  Values smaller than Threshold bytes are
+// stored uncompressed.
+type Compression struct {
+	Compressor Compressor
+	Threshold  int
+}
+
+// Compressed values are marked with this usermeta header (in addition to
+// Content-Encoding) so we never decompress a value someone else encoded.
+const compressionMeta = "X-Riak-Meta-Riak-Compression"
+
+var ErrUnknownEncoding = os.NewError("Unknown compression encoding")
+
+var compressorLock sync.RWMutex
+var compressors = map[string]Compressor{
+	"gzip": GzipCompressor{},
+}
+
+func RegisterCompressor(c Compressor) {
+	compressorLock.Lock()
+	compressors[c.Encoding()] = c
+	compressorLock.Unlock()
+}
+
+type GzipCompressor struct{}
+
+func (GzipCompressor) Encoding() string {
+	return "gzip"
+}
+
+func (GzipCompressor) Compress(in []byte) (out []byte, err os.Error) {
+	buff := bytes.NewBuffer(nil)
+	w, err := gzip.NewWriter(buff)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(in)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		out = buff.Bytes()
+	}
+	return
+}
+
+func (GzipCompressor) Decompress(in []byte) (out []byte, err os.Error) {
+	r, err := gzip.NewReader(bytes.NewBuffer(in))
+	if err == nil {
+		out, err = ioutil.ReadAll(r)
+		r.Close()
+	}
+	return
+}
+
+// Returns the (possibly) compressed body and the headers to send with it; hdrs
+// itself is never modified.  If compression fails or doesn't save space, the
+// body and headers are returned as-is.
+func (self Client) compressBody(body []byte, hdrs http.Header) ([]byte, http.Header) {
+	cmp := self.Compression
+	if cmp == nil || cmp.Compressor == nil || len(body) < cmp.Threshold || hdrs.Get("Content-Encoding") != "" {
+		return body, hdrs
+	}
+	out, err := cmp.Compressor.Compress(body)
+	if err != nil || len(out) >= len(body) {
+		return body, hdrs
+	}
+	hdrs = copyHeader(hdrs)
+	hdrs.Set("Content-Encoding", cmp.Compressor.Encoding())
+	hdrs.Set(compressionMeta, cmp.Compressor.Encoding())
+	return out, hdrs
+}
+
+// If resp was compressed by compressBody, its body is replaced with the
+// decompressed value and the compression headers are removed.  Uncompressed
+// responses are left untouched.
+func decompressResponse(resp *http.Response) (err os.Error) {
+	enc := resp.Header.Get(compressionMeta)
+	if enc == "" {
+		return
+	}
+	compressorLock.RLock()
+	cmp, ok := compressors[enc]
+	compressorLock.RUnlock()
+	if !ok {
+		return os.NewError(ErrUnknownEncoding.String() + ": " + enc)
+	}
+	in, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return
+	}
+	out, err := cmp.Decompress(in)
+	if err != nil {
+		return
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewBuffer(out))
+	resp.ContentLength = int64(len(out))
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del(compressionMeta)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(out)))
+	return
+}
@@ -0,0 +1,59 @@
+package riak
+
+import (
+	"bytes"
+	"http"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCompressedPutItemRequest(t *testing.T) {
+	c := testClient(t)
+	c.Compression = &Compression{Compressor: GzipCompressor{}, Threshold: 64}
+	body := []byte(strings.Repeat("hello world ", 64))
+	req := putItemRequest(c, TESTING_BUCKET, "TestCompressedPutItemRequest", body, nil, nil)
+	fatalIf(t, req.Header.Get("Content-Encoding") != "gzip", "Expected gzip encoding, got '%s'", req.Header.Get("Content-Encoding"))
+	fatalIf(t, req.Header.Get(compressionMeta) != "gzip", "Expected compression meta, got '%s'", req.Header.Get(compressionMeta))
+	fatalIf(t, req.ContentLength >= int64(len(body)), "Body wasn't compressed: %d", req.ContentLength)
+
+	resp := &http.Response{Header: req.Header, Body: req.Body}
+	err := decompressResponse(resp)
+	fatalIf(t, err != nil, "Couldn't decompress: %v", err)
+	out, _ := ioutil.ReadAll(resp.Body)
+	fatalIf(t, !bytes.Equal(out, body), "Round trip failed: %s", out)
+	fatalIf(t, resp.Header.Get("Content-Encoding") != "", "Content-Encoding should be removed")
+	fatalIf(t, resp.ContentLength != int64(len(body)), "Wrong content length: %d", resp.ContentLength)
+}
+
+func TestSmallPutItemRequestUncompressed(t *testing.T) {
+	c := testClient(t)
+	c.Compression = &Compression{Compressor: GzipCompressor{}, Threshold: 64}
+	req := putItemRequest(c, TESTING_BUCKET, "TestSmallPutItemRequestUncompressed", []byte("hello world"), nil, nil)
+	fatalIf(t, req.Header.Get("Content-Encoding") != "", "Small value shouldn't be compressed")
+	fatalIf(t, req.ContentLength != 11, "Wrong content length: %d", req.ContentLength)
+}
+
+func TestDecompressUncompressedResponse(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{},
+		Body:   ioutil.NopCloser(bytes.NewBufferString("hello world")),
+	}
+	err := decompressResponse(resp)
+	fatalIf(t, err != nil, "Unexpected error: %v", err)
+	out, _ := ioutil.ReadAll(resp.Body)
+	fatalIf(t, string(out) != "hello world", "Uncompressed body was modified: %s", out)
+}
+
+func TestCompressionLeavesCallerHeaders(t *testing.T) {
+	c := testClient(t)
+	c.Compression = &Compression{Compressor: GzipCompressor{}, Threshold: 64}
+	hdrs := http.Header{"Content-Type": []string{"text/plain"}}
+	body := []byte(strings.Repeat("hello world ", 64))
+	req := putItemRequest(c, TESTING_BUCKET, "TestCompressionLeavesCallerHeaders", body, hdrs, nil)
+	fatalIf(t, req.Header.Get("Content-Encoding") != "gzip", "Expected gzip encoding, got '%s'", req.Header.Get("Content-Encoding"))
+	fatalIf(t, hdrs.Get("Content-Encoding") != "" || hdrs.Get(compressionMeta) != "", "Caller's headers modified: %v", hdrs)
+	// so a second put with the same headers is compressed too
+	req = putItemRequest(c, TESTING_BUCKET, "TestCompressionLeavesCallerHeaders", body, hdrs, nil)
+	fatalIf(t, req.ContentLength >= int64(len(body)), "Second body wasn't compressed: %d", req.ContentLength)
+}
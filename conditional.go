@@ -0,0 +1,74 @@
+package riak
+
+import (
+	"http"
+	"os"
+	"time"
+)
+
+// Exists checks for a key with a HEAD request, without fetching its value.
+// GetItemIfChanged revalidates a previously fetched value: if it hasn't
+// changed, it returns ErrNotModified rather than the value.
+
+var ErrNotModified = os.NewError("Not modified")
+
+// What's needed to revalidate a previously fetched value.
+type Validators struct {
+	ETag string
+	// Seconds since the epoch; 0 if unknown
+	LastModified int64
+}
+
+func ResponseValidators(resp *http.Response) (v Validators) {
+	v.ETag = resp.Header.Get("Etag")
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		if t, err := time.Parse(http.TimeFormat, lm); err == nil {
+			v.LastModified = t.Seconds()
+		}
+	}
+	return
+}
+
+// Sets If-None-Match in hdrs, or If-Modified-Since if the etag isn't known.
+// Never both: webmachine still checks If-Modified-Since after an etag
+// mismatch, so a value rewritten within the same second would come back as
+// not modified.
+func (self Validators) setHeaders(hdrs http.Header) http.Header {
+	if hdrs == nil {
+		hdrs = http.Header{}
+	}
+	if self.ETag != "" {
+		hdrs.Set("If-None-Match", self.ETag)
+	} else if self.LastModified != 0 {
+		hdrs.Set("If-Modified-Since", time.SecondsToUTC(self.LastModified).Format(http.TimeFormat))
+	}
+	return hdrs
+}
+
+func headItemRequest(c Client, bucket, key string, parms http.Values) (req *http.Request) {
+	req = c.request("HEAD", c.keyPath(bucket, key), nil, parms)
+	return
+}
+
+// Siblings count as existing.
+func Exists(c Client, bucket, key string, parms http.Values, cc *http.ClientConn) (exists bool, err os.Error) {
+	req := headItemRequest(c, bucket, key, parms)
+	found := func(resp *http.Response) os.Error {
+		exists = true
+		return closef(resp)
+	}
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+		-1:  debugFailf(os.Stdout, false, "Exists failed"),
+		400: func(*http.Response) os.Error { return ErrBadRequest },
+		503: func(*http.Response) os.Error { return ErrServiceUnavailable },
+		404: closef,
+		200: found,
+		300: found,
+	})
+	return
+}
+
+// As GetItem, but returns ErrNotModified if the value still matches v.
+func GetItemIfChanged(c Client, bucket, key string, v Validators, hdrs http.Header, parms http.Values, cc *http.ClientConn) (resp *http.Response, err os.Error) {
+	return GetItem(c, bucket, key, v.setHeaders(copyHeader(hdrs)), parms, cc)
+}
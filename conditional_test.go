@@ -0,0 +1,32 @@
+package riak
+
+import (
+	"http"
+	"path"
+	"testing"
+)
+
+func TestHeadItemRequest(t *testing.T) {
+	expPath := path.Join(TESTING_RIAK.Path, "riak", TESTING_BUCKET, "TestHeadItemRequest")
+	c := testClient(t)
+	req := headItemRequest(c, TESTING_BUCKET, "TestHeadItemRequest", nil)
+	fatalIf(t, req.Method != "HEAD", "Unexpected method: %s", req.Method)
+	fatalIf(t, req.URL.Path != expPath, "Unexpected path: %s", req.URL.Path)
+}
+
+func TestValidators(t *testing.T) {
+	resp := &http.Response{Header: http.Header{
+		"Etag":          []string{`"5mAmN3k1Jp8QSxBZ4qyLwp"`},
+		"Last-Modified": []string{"Tue, 01 Nov 2011 10:00:00 GMT"},
+	}}
+	v := ResponseValidators(resp)
+	fatalIf(t, v.ETag != `"5mAmN3k1Jp8QSxBZ4qyLwp"`, "Wrong etag: %s", v.ETag)
+	fatalIf(t, v.LastModified != 1320141600, "Wrong last-modified: %d", v.LastModified)
+	hdrs := v.setHeaders(nil)
+	fatalIf(t, hdrs.Get("If-None-Match") != v.ETag, "Wrong If-None-Match: %s", hdrs.Get("If-None-Match"))
+	fatalIf(t, hdrs.Get("If-Modified-Since") != "", "If-Modified-Since sent with an etag: %s", hdrs.Get("If-Modified-Since"))
+	hdrs = Validators{LastModified: v.LastModified}.setHeaders(nil)
+	fatalIf(t, hdrs.Get("If-Modified-Since") != "Tue, 01 Nov 2011 10:00:00 GMT", "Wrong If-Modified-Since: %s", hdrs.Get("If-Modified-Since"))
+	hdrs = Validators{}.setHeaders(nil)
+	fatalIf(t, len(hdrs) != 0, "Empty validators shouldn't set headers: %v", hdrs)
+}
@@ -0,0 +1,179 @@
+package riak
+
+import (
+	"http"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A Context allows an operation to be canceled (or timed out).  Use
+// Client.WithContext to attach one; any operation using that Client is then
+// aborted when the context is canceled: its connection is closed, streaming
+// decoders stop, result channels are closed, and the operation returns the
+// context's error (ErrCanceled or ErrTimeout).
+//
+// Dialing isn't covered: net.Dial can't be interrupted, so a canceled (or
+// timed out) operation still waits for its connection attempt to finish or
+// fail before returning.
+
+var ErrCanceled = os.NewError("Operation canceled")
+var ErrTimeout = os.NewError("Operation timed out")
+
+type Context struct {
+	done  chan bool
+	lock  sync.Mutex
+	err   os.Error
+	timer *time.Timer
+}
+
+func NewContext() *Context {
+	return &Context{done: make(chan bool)}
+}
+
+// Returns a context that is canceled (with ErrTimeout) after ns nanoseconds.
+// Call Stop once the operations are finished to release its timer.
+func NewTimeoutContext(ns int64) (ctx *Context) {
+	ctx = NewContext()
+	ctx.timer = time.AfterFunc(ns, func() { ctx.cancel(ErrTimeout) })
+	return
+}
+
+// Stops a NewTimeoutContext's timer; the context won't time out after this.
+func (self *Context) Stop() {
+	if self.timer != nil {
+		self.timer.Stop()
+	}
+}
+
+func (self *Context) Cancel() {
+	self.cancel(ErrCanceled)
+}
+
+func (self *Context) cancel(err os.Error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.err == nil {
+		self.err = err
+		close(self.done)
+	}
+}
+
+// Closed when the context is canceled.
+func (self *Context) Done() <-chan bool {
+	return self.done
+}
+
+// nil until the context is canceled.
+func (self *Context) Err() os.Error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.err
+}
+
+// Waits ns nanoseconds, or until the client's context is canceled.
+func (self Client) sleep(ns int64) os.Error {
+	if self.Context == nil {
+		time.Sleep(ns)
+		return nil
+	}
+	select {
+	case <-time.After(ns):
+	case <-self.Context.Done():
+		return self.Context.Err()
+	}
+	return nil
+}
+
+// Returns a copy of the client whose operations are bound to ctx.
+func (self Client) WithContext(ctx *Context) Client {
+	self.Context = ctx
+	return self
+}
+
+// Returns a copy of the client bound to a new context, which is also canceled
+// when the client's own context (if any) is.  Cancel the new context once done
+// with it.
+func (self Client) withChildContext() (Client, *Context) {
+	ctx := NewContext()
+	if parent := self.Context; parent != nil {
+		go func() {
+			select {
+			case <-parent.Done():
+				ctx.cancel(parent.Err())
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return self.WithContext(ctx), ctx
+}
+
+func (self Client) canceled() os.Error {
+	if self.Context == nil {
+		return nil
+	}
+	return self.Context.Err()
+}
+
+func (self Client) sendResponse(ch chan<- *http.Response, resp *http.Response) (err os.Error) {
+	if self.Context == nil {
+		ch <- resp
+		return
+	}
+	select {
+	case ch <- resp:
+	case <-self.Context.Done():
+		err = self.Context.Err()
+	}
+	return
+}
+
+func (self Client) sendKey(ch chan<- string, key string) (err os.Error) {
+	if self.Context == nil {
+		ch <- key
+		return
+	}
+	select {
+	case ch <- key:
+	case <-self.Context.Done():
+		err = self.Context.Err()
+	}
+	return
+}
+
+// Performs request, closing the connection if ctx is canceled before the response
+// body has been read (or closed).
+func contextRoundTrip(ctx *Context, cc *http.ClientConn, request *http.Request) (resp *http.Response, err os.Error) {
+	var conn net.Conn
+	if cc == nil {
+		conn, err = dialConn(request.Host, request.URL.Scheme)
+		if err != nil {
+			return
+		}
+		cc = http.NewClientConn(conn, nil)
+	}
+	stop := make(chan bool)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if conn != nil {
+				conn.Close()
+			} else {
+				cc.Close()
+			}
+		case <-stop:
+			// we dialed this connection for a single request
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+	resp, err = cc.Do(request)
+	if resp == nil || (err != nil && err != http.ErrPersistEOF) {
+		close(stop)
+		return
+	}
+	resp.Body = &finishBody{ReadCloser: resp.Body, finished: func() { close(stop) }}
+	return
+}
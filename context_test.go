@@ -0,0 +1,48 @@
+package riak
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContextCancel(t *testing.T) {
+	ctx := NewContext()
+	fatalIf(t, ctx.Err() != nil, "New context shouldn't have an error: %v", ctx.Err())
+	ctx.Cancel()
+	ctx.Cancel()
+	<-ctx.Done()
+	fatalIf(t, ctx.Err() != ErrCanceled, "Expected ErrCanceled, got %v", ctx.Err())
+}
+
+func TestContextTimeout(t *testing.T) {
+	ctx := NewTimeoutContext(1e6)
+	select {
+	case <-ctx.Done():
+	case <-time.After(1e9):
+		t.Fatalf("Context didn't time out")
+	}
+	fatalIf(t, ctx.Err() != ErrTimeout, "Expected ErrTimeout, got %v", ctx.Err())
+}
+
+func TestCanceledOperations(t *testing.T) {
+	ctx := NewContext()
+	ctx.Cancel()
+	c := testClient(t).WithContext(ctx)
+	err := Ping(c, nil)
+	fatalIf(t, err != ErrCanceled, "Expected ErrCanceled from ping, got %v", err)
+	_, err = GetItem(c, TESTING_BUCKET, "TestCanceledOperations", nil, nil, nil)
+	fatalIf(t, err != ErrCanceled, "Expected ErrCanceled from get, got %v", err)
+
+	outch := make(chan string)
+	err = ListKeys(c, TESTING_BUCKET, outch, nil)
+	fatalIf(t, err != ErrCanceled, "Expected ErrCanceled from list keys, got %v", err)
+	_, ok := <-outch
+	fatalIf(t, ok, "ListKeys didn't close its channel")
+}
+
+func TestContextStop(t *testing.T) {
+	ctx := NewTimeoutContext(1e6)
+	ctx.Stop()
+	time.Sleep(50e6)
+	fatalIf(t, ctx.Err() != nil, "Stopped context timed out: %v", ctx.Err())
+}
@@ -0,0 +1,72 @@
+package riak
+
+import (
+	"bytes"
+	"http"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Create stores a value under a key chosen by riak (by POSTing to the bucket).
+
+var ErrNoLocation = os.NewError("Server didn't return the location of the new object")
+
+type CreateOptions struct {
+	// If nil, Content-Type defaults to application/binary, as for PutItem
+	Header http.Header
+	Parms  http.Values
+	// Return the stored object (and its vclock) in resp
+	ReturnBody bool
+}
+
+func createRequest(c Client, bucket string, value []byte, opts CreateOptions) (req *http.Request) {
+	hdrs := copyHeader(opts.Header)
+	if hdrs == nil {
+		hdrs = http.Header{"Content-Type": []string{"application/binary"}}
+	}
+	parms := http.Values{}
+	for k, v := range opts.Parms {
+		parms[k] = v
+	}
+	if opts.ReturnBody {
+		parms.Set("returnbody", "true")
+	}
+	value, hdrs = c.compressBody(value, hdrs)
+	// keyPath with no key is the bucket (or its key list, for the /buckets URLs)
+	req = c.request("POST", c.keyPath(bucket, ""), hdrs, parms)
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(value))
+	req.ContentLength = int64(len(value))
+	return
+}
+
+// Returns the (unescaped) key from a Location header, e.g. /riak/bucket/key.
+func keyFromLocation(loc string) (key string, err os.Error) {
+	if i := strings.LastIndex(loc, "/"); i >= 0 && i < len(loc)-1 {
+		return unescapeName(loc[i+1:]), nil
+	}
+	return "", ErrNoLocation
+}
+
+// Stores value in bucket under a new key.  resp is only set if opts.ReturnBody is.
+func Create(c Client, bucket string, value []byte, opts CreateOptions, cc *http.ClientConn) (key string, resp *http.Response, err os.Error) {
+	req := createRequest(c, bucket, value, opts)
+	// riak answers 201 (with the body, if asked for) to a POST
+	created := func(r *http.Response) (err os.Error) {
+		if key, err = keyFromLocation(r.Header.Get("Location")); err == nil && opts.ReturnBody {
+			keepBody(r)
+			if err = decompressResponse(r); err == nil {
+				resp = r
+			}
+		}
+		return
+	}
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+		-1:  debugFailf(os.Stdout, true, "Create failed"),
+		400: func(*http.Response) os.Error { return ErrBadRequest },
+		503: func(*http.Response) os.Error { return ErrServiceUnavailable },
+		201: created,
+		200: created,
+	})
+	return
+}
@@ -0,0 +1,35 @@
+package riak
+
+import (
+	"path"
+	"testing"
+)
+
+func TestCreateRequest(t *testing.T) {
+	expPath := path.Join(TESTING_RIAK.Path, "riak", TESTING_BUCKET)
+	c := testClient(t)
+	req := createRequest(c, TESTING_BUCKET, []byte("value"), CreateOptions{ReturnBody: true})
+	fatalIf(t, req.Method != "POST", "Unexpected method: %s", req.Method)
+	fatalIf(t, req.URL.Path != expPath, "Unexpected path: %s", req.URL.Path)
+	fatalIf(t, req.URL.RawQuery != "returnbody=true", "Unexpected query: %s", req.URL.RawQuery)
+	fatalIf(t, req.ContentLength != 5, "Got a bad content length: %d", req.ContentLength)
+	fatalIf(t, req.Header.Get("Content-Type") != "application/binary", "Unexpected content-type: %s", req.Header.Get("Content-Type"))
+	fatalIf(t, req.Header.Get("X-Riak-ClientId") != c.ClientId, "Missing client id")
+
+	req = createRequest(c.WithURLScheme(URLSchemeBuckets), TESTING_BUCKET, nil, CreateOptions{})
+	fatalIf(t, req.URL.Path != "/buckets/"+TESTING_BUCKET+"/keys", "Unexpected /buckets path: %s", req.URL.Path)
+	fatalIf(t, req.URL.RawQuery != "", "Unexpected query: %s", req.URL.RawQuery)
+}
+
+func TestKeyFromLocation(t *testing.T) {
+	for loc, key := range map[string]string{
+		"/riak/b/Abc123":         "Abc123",
+		"/buckets/b/keys/Abc123": "Abc123",
+		"/riak/b/a%2Fb":          "a/b",
+	} {
+		k, err := keyFromLocation(loc)
+		fatalIf(t, err != nil || k != key, "keyFromLocation(%q) = %q, %v", loc, k, err)
+	}
+	_, err := keyFromLocation("")
+	fatalIf(t, err != ErrNoLocation, "Expected ErrNoLocation, got %v", err)
+}
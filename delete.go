@@ -0,0 +1,138 @@
+package riak
+
+import (
+	"http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Delete is DeleteItem with the object's vclock and typed quorums.  On
+// allow_mult buckets, a delete without the vclock can leave the value's
+// siblings behind (or be undone by a write that raced it).
+//
+// Riak keeps a tombstone (a deleted value with a vclock) for a while after a
+// delete; a GET with deletedvclock=true reports it as a 404 with X-Riak-Vclock.
+
+var ErrNotDeleted = os.NewError("Key still exists after delete")
+
+type DeleteOptions struct {
+	// The vclock of the value being deleted, from a previous GET
+	Vclock string
+	// nil leaves the bucket's default
+	RW, R, W, PR, PW, DW *QuorumValue
+	// Re-read the key (with deletedvclock=true) after deleting it, until its
+	// tombstone has been reaped or VerifyTimeout nanoseconds have passed (zero
+	// to read it once).  VerifyInterval is the pause between reads; zero for
+	// the default.
+	Verify         bool
+	VerifyTimeout  int64
+	VerifyInterval int64
+}
+
+type DeleteResult struct {
+	// false if the key was already gone
+	Deleted bool
+	// The key was already gone (or, with Verify, has been deleted) but riak
+	// still has its tombstone, whose vclock is Vclock.
+	Tombstone bool
+	Vclock    string
+	// With Verify: the tombstone was reaped before VerifyTimeout.
+	Reaped bool
+}
+
+const defaultVerifyInterval = 1e8
+
+func (self QuorumValue) param() string {
+	switch self {
+	case QUORUM:
+		return "quorum"
+	case ALL:
+		return "all"
+	case ZERO:
+		return "0"
+	}
+	return strconv.Itoa(int(self))
+}
+
+func (self DeleteOptions) parms() (parms http.Values) {
+	parms = http.Values{}
+	for name, q := range map[string]*QuorumValue{"rw": self.RW, "r": self.R, "w": self.W, "pr": self.PR, "pw": self.PW, "dw": self.DW} {
+		if q != nil {
+			parms.Set(name, q.param())
+		}
+	}
+	return
+}
+
+func deleteRequest(c Client, bucket, key string, opts DeleteOptions) (req *http.Request) {
+	hdrs := http.Header{}
+	if opts.Vclock != "" {
+		hdrs.Set("X-Riak-Vclock", opts.Vclock)
+	}
+	req = c.request("DELETE", c.keyPath(bucket, key), hdrs, opts.parms())
+	return
+}
+
+// Reads bucket/key with deletedvclock=true, using the read quorums in opts.
+func tombstoneRequest(c Client, bucket, key string, opts DeleteOptions) (req *http.Request) {
+	parms := http.Values{"deletedvclock": []string{"true"}}
+	if opts.R != nil {
+		parms.Set("r", opts.R.param())
+	}
+	if opts.PR != nil {
+		parms.Set("pr", opts.PR.param())
+	}
+	req = getItemRequest(c, bucket, key, nil, parms)
+	return
+}
+
+func (self *DeleteResult) setTombstone(resp *http.Response) os.Error {
+	self.Vclock = resp.Header.Get("X-Riak-Vclock")
+	self.Tombstone = self.Vclock != ""
+	return closef(resp)
+}
+
+func Delete(c Client, bucket, key string, opts DeleteOptions, cc *http.ClientConn) (res DeleteResult, err os.Error) {
+	defer c.invalidate(bucket, key)
+	req := deleteRequest(c, bucket, key, opts)
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+		-1:  debugFailf(os.Stdout, true, "Delete failed"),
+		400: func(*http.Response) os.Error { return ErrBadRequest },
+		503: func(*http.Response) os.Error { return ErrServiceUnavailable },
+		404: func(resp *http.Response) os.Error { return res.setTombstone(resp) },
+		204: func(resp *http.Response) os.Error {
+			res.Deleted = true
+			return closef(resp)
+		},
+	})
+	if err != nil || !res.Deleted || !opts.Verify {
+		return
+	}
+	interval := opts.VerifyInterval
+	if interval <= 0 {
+		interval = defaultVerifyInterval
+	}
+	deadline := time.Nanoseconds() + opts.VerifyTimeout
+	for {
+		req = tombstoneRequest(c, bucket, key, opts)
+		err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+			-1: debugFailf(os.Stdout, true, "Delete verification failed"),
+			404: func(resp *http.Response) os.Error {
+				err := res.setTombstone(resp)
+				res.Reaped = !res.Tombstone
+				return err
+			},
+			// written again since (or the delete didn't reach enough replicas)
+			200: func(resp *http.Response) os.Error { closef(resp); return ErrNotDeleted },
+			300: func(resp *http.Response) os.Error { closef(resp); return ErrNotDeleted },
+		})
+		if err != nil || res.Reaped || time.Nanoseconds()+interval > deadline {
+			return
+		}
+		if err = c.sleep(interval); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package riak
+
+import (
+	"http"
+	"testing"
+)
+
+func TestDeleteRequest(t *testing.T) {
+	c := testClient(t)
+	all, one := ALL, QuorumValue(1)
+	req := deleteRequest(c, TESTING_BUCKET, "TestDeleteRequest", DeleteOptions{Vclock: "abc", RW: &all, PR: &one})
+	fatalIf(t, req.Method != "DELETE", "Unexpected method: %s", req.Method)
+	fatalIf(t, req.Header.Get("X-Riak-Vclock") != "abc", "Vclock not sent: %v", req.Header)
+	fatalIf(t, req.FormValue("rw") != "all" || req.FormValue("pr") != "1", "Wrong quorums: %s", req.URL.RawQuery)
+	fatalIf(t, req.FormValue("pw") != "", "Unset quorum sent: %s", req.URL.RawQuery)
+	zero := ZERO
+	req = deleteRequest(c, TESTING_BUCKET, "TestDeleteRequest", DeleteOptions{PR: &zero})
+	fatalIf(t, req.FormValue("pr") != "0", "Zero quorum not sent as 0: %s", req.URL.RawQuery)
+
+	req = tombstoneRequest(c, TESTING_BUCKET, "TestDeleteRequest", DeleteOptions{RW: &all})
+	fatalIf(t, req.Method != "GET", "Unexpected method: %s", req.Method)
+	fatalIf(t, req.URL.RawQuery != "deletedvclock=true", "Wrong verification query: %s", req.URL.RawQuery)
+}
+
+func TestDeleteVerify(t *testing.T) {
+	c := replayClient(t,
+		Interaction{RecordedRequest{Method: "DELETE", Path: "/riak/b/k"}, RecordedResponse{StatusCode: 204}},
+		Interaction{RecordedRequest{Method: "GET", Path: "/riak/b/k", Query: "deletedvclock=true"}, RecordedResponse{StatusCode: 404}},
+	)
+	res, err := Delete(c, "b", "k", DeleteOptions{Verify: true}, nil)
+	fatalIf(t, err != nil, "Delete failed: %v", err)
+	fatalIf(t, !res.Deleted || !res.Reaped || res.Tombstone, "Wrong result: %+v", res)
+
+	c = replayClient(t,
+		Interaction{RecordedRequest{Method: "DELETE", Path: "/riak/b/k"}, RecordedResponse{StatusCode: 204}},
+		Interaction{RecordedRequest{Method: "GET", Path: "/riak/b/k", Query: "deletedvclock=true"}, RecordedResponse{StatusCode: 200, Body: []byte("back")}},
+	)
+	_, err = Delete(c, "b", "k", DeleteOptions{Verify: true}, nil)
+	fatalIf(t, err != ErrNotDeleted, "Expected ErrNotDeleted, got %v", err)
+
+	c = replayClient(t, Interaction{
+		RecordedRequest{Method: "DELETE", Path: "/riak/b/k"},
+		RecordedResponse{StatusCode: 404, Header: http.Header{"X-Riak-Vclock": []string{"abc"}}},
+	})
+	res, err = Delete(c, "b", "k", DeleteOptions{Verify: true}, nil)
+	fatalIf(t, err != nil, "Delete failed: %v", err)
+	fatalIf(t, res.Deleted || !res.Tombstone || res.Vclock != "abc", "Wrong result: %+v", res)
+}
@@ -0,0 +1,143 @@
+package riak
+
+import (
+	"http"
+	"io/ioutil"
+	"json"
+	"mime"
+	"os"
+	"regexp"
+)
+
+// The root resource ("/") lists the resources a node serves, by the name of
+// the webmachine module implementing each, e.g.
+//	{"riak_kv_wm_buckets":"/riak","riak_kv_wm_mapred":"/mapred",...}
+// Discover fetches it; a Client with Resources set routes requests to the
+// advertised paths rather than the defaults.
+
+type Resources map[string]string
+
+const (
+	ResourceBuckets   = "riak_kv_wm_buckets"
+	ResourceObject    = "riak_kv_wm_object"
+	ResourceProps     = "riak_kv_wm_props"
+	ResourceKeyList   = "riak_kv_wm_keylist"
+	ResourceIndex     = "riak_kv_wm_index"
+	ResourceLinks     = "riak_kv_wm_link_walker"
+	ResourceMapReduce = "riak_kv_wm_mapred"
+	ResourcePing      = "riak_kv_wm_ping"
+	ResourceStats     = "riak_kv_wm_stats"
+	ResourceLuwak     = "luwak_wm_file"
+	ResourceSolr      = "riak_solr_indexer_wm"
+)
+
+// Riak 0.14 and earlier call the object resource riak_kv_wm_raw.
+const resourceObjectOld = "riak_kv_wm_raw"
+
+var defaultResources = Resources{
+	ResourceBuckets:   "/riak",
+	ResourceObject:    "/riak",
+	ResourceLinks:     "/riak",
+	ResourceProps:     "/buckets",
+	ResourceKeyList:   "/buckets",
+	ResourceIndex:     "/buckets",
+	ResourceMapReduce: "/mapred",
+	// "/" answers a GET just as well as /ping, and older nodes have no /ping.
+	ResourcePing:  "/",
+	ResourceStats: "/stats",
+	ResourceLuwak: "/luwak",
+	ResourceSolr:  "/solr",
+}
+
+// Returns a copy of the client that routes requests using res.
+func (self Client) WithResources(res Resources) Client {
+	self.Resources = res
+	return self
+}
+
+// Returns the path (relative to RootURL) of the named resource.
+func (self Client) resourcePath(name string) string {
+	if p, ok := self.Resources[name]; ok {
+		return p
+	}
+	if name == ResourceObject {
+		if p, ok := self.Resources[resourceObjectOld]; ok {
+			return p
+		}
+	}
+	return defaultResources[name]
+}
+
+func discoverRequest(c Client) (req *http.Request) {
+	req = c.request("GET", "/", http.Header{"Accept": []string{"application/json"}}, nil)
+	return
+}
+
+// Nodes that ignore the Accept header list the resources as HTML links.
+var resourceLink = regexp.MustCompile(`<a href="([^"]*)">([^<]*)</a>`)
+
+// A "name":"path" pair of the JSON listing.  The listing is matched pair by
+// pair, rather than unmarshalled, because riak 1.x lists some names twice.
+var resourcePair = regexp.MustCompile(`("[^"\\]*(\\.[^"\\]*)*")[ \t\r\n]*:[ \t\r\n]*("[^"\\]*(\\.[^"\\]*)*")`)
+
+// Riak 1.x lists these twice, under both the old prefix and /buckets.  Their
+// paths are used with the old URL layout, so the /buckets entry is ignored.
+var legacyResources = map[string]bool{
+	ResourceBuckets: true,
+	ResourceObject:  true,
+	ResourceLinks:   true,
+}
+
+func (self Resources) add(name, path string) {
+	if _, ok := self[name]; ok && legacyResources[name] && path == "/buckets" {
+		return
+	}
+	self[name] = path
+}
+
+func parseResources(ctype string, body []byte) (res Resources, err os.Error) {
+	res = Resources{}
+	if mtype, _ := mime.ParseMediaType(ctype); mtype == "application/json" {
+		// still unmarshalled, to reject anything that isn't a JSON object of strings
+		if err = json.Unmarshal(body, &Resources{}); err != nil {
+			return
+		}
+		for _, m := range resourcePair.FindAllSubmatch(body, -1) {
+			var name, path string
+			if err = json.Unmarshal(m[1], &name); err == nil {
+				err = json.Unmarshal(m[3], &path)
+			}
+			if err != nil {
+				return
+			}
+			res.add(name, path)
+		}
+		return
+	}
+	for _, m := range resourceLink.FindAllSubmatch(body, -1) {
+		res.add(string(m[2]), string(m[1]))
+	}
+	if len(res) == 0 {
+		err = os.NewError("No resources found in the root listing")
+	}
+	return
+}
+
+// Fetches the resource listing of the node.  Use Client.WithResources to route
+// requests according to it.
+func Discover(c Client, cc *http.ClientConn) (res Resources, err os.Error) {
+	req := discoverRequest(c)
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+		-1:  failf("Discover failed"),
+		503: func(*http.Response) os.Error { return ErrServiceUnavailable },
+		200: func(r *http.Response) (err os.Error) {
+			defer r.Body.Close()
+			body, err := ioutil.ReadAll(r.Body)
+			if err == nil {
+				res, err = parseResources(r.Header.Get("Content-Type"), body)
+			}
+			return
+		},
+	})
+	return
+}
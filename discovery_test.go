@@ -0,0 +1,59 @@
+package riak
+
+import (
+	"http"
+	"testing"
+)
+
+func TestParseResources(t *testing.T) {
+	res, err := parseResources("application/json", []byte(`{"riak_kv_wm_object":"/kv","riak_kv_wm_mapred":"/mr"}`))
+	fatalIf(t, err != nil, "Couldn't parse JSON listing: %v", err)
+	fatalIf(t, res[ResourceObject] != "/kv" || res[ResourceMapReduce] != "/mr", "Wrong resources: %v", res)
+
+	res, err = parseResources("text/html", []byte(`<html><body><ul><li><a href="/old">riak_kv_wm_raw</a></li><li><a href="/ping">riak_kv_wm_ping</a></li></ul></body></html>`))
+	fatalIf(t, err != nil, "Couldn't parse HTML listing: %v", err)
+	fatalIf(t, res[resourceObjectOld] != "/old" || res[ResourcePing] != "/ping", "Wrong resources: %v", res)
+	c := testClient(t).WithResources(res)
+	fatalIf(t, c.keyPath("b", "k") != "/old/b/k", "Old object resource not used: %s", c.keyPath("b", "k"))
+}
+
+// The root listing of a riak 1.0 node, which names some resources twice.
+const riak10Resources = `{"riak_kv_wm_buckets":"/riak","riak_kv_wm_buckets":"/buckets",` +
+	`"riak_kv_wm_index":"/buckets","riak_kv_wm_keylist":"/buckets",` +
+	`"riak_kv_wm_link_walker":"/riak","riak_kv_wm_link_walker":"/buckets",` +
+	`"riak_kv_wm_mapred":"/mapred","riak_kv_wm_object":"/riak","riak_kv_wm_object":"/buckets",` +
+	`"riak_kv_wm_ping":"/ping","riak_kv_wm_props":"/buckets","riak_kv_wm_stats":"/stats",` +
+	`"riak_solr_indexer_wm":"/solr","riak_solr_searcher_wm":"/solr"}`
+
+func TestParseDuplicateResources(t *testing.T) {
+	res, err := parseResources("application/json", []byte(riak10Resources))
+	fatalIf(t, err != nil, "Couldn't parse JSON listing: %v", err)
+	for _, name := range []string{ResourceBuckets, ResourceObject, ResourceLinks} {
+		fatalIf(t, res[name] != "/riak", "Wrong path for %s: %s", name, res[name])
+	}
+	fatalIf(t, res[ResourceKeyList] != "/buckets" || res[ResourceMapReduce] != "/mapred", "Wrong resources: %v", res)
+	c := testClient(t).WithResources(res)
+	fatalIf(t, c.keyPath("b", "k") != "/riak/b/k", "Wrong key path: %s", c.keyPath("b", "k"))
+
+	// listed the other way round
+	res, err = parseResources("text/html", []byte(`<ul><li><a href="/buckets">riak_kv_wm_object</a></li><li><a href="/riak">riak_kv_wm_object</a></li></ul>`))
+	fatalIf(t, err != nil || res[ResourceObject] != "/riak", "Wrong object path: %v (%v)", res, err)
+
+	_, err = parseResources("application/json", []byte(`{"riak_kv_wm_object":`))
+	fatalIf(t, err == nil, "Truncated listing accepted")
+}
+
+func TestResourcePaths(t *testing.T) {
+	c := testClient(t)
+	fatalIf(t, c.bucketPath("b") != "/riak/b", "Wrong default bucket path: %s", c.bucketPath("b"))
+	c = c.WithResources(Resources{ResourceBuckets: "/kv", ResourceObject: "/kv", ResourceMapReduce: "/mr"})
+	fatalIf(t, c.bucketPath("b") != "/kv/b", "Wrong bucket path: %s", c.bucketPath("b"))
+	req := mapReduceRequest(c, []byte("{}"), nil)
+	fatalIf(t, req.URL.Path != "/mr", "Wrong mapred path: %s", req.URL.Path)
+	// paths are relative to the root URL
+	c.RootURL = http.URL{Scheme: "http", Host: "localhost:8098", Path: "/proxy/"}
+	req = getItemRequest(c, "b", "k", nil, nil)
+	fatalIf(t, req.URL.Path != "/proxy/kv/b/k", "Wrong path under a root prefix: %s", req.URL.Path)
+	req = statsRequest(c)
+	fatalIf(t, req.URL.Path != "/proxy/stats", "Unadvertised resource should use the default: %s", req.URL.Path)
+}
@@ -2,30 +2,117 @@ package riak
 
 import (
 	"http"
+	"io"
+	"io/ioutil"
 	"os"
+	"sync"
 )
 
 // TODO: This function could use some dedicated testing
 
+// Closes the connection once the response body has been read (or closed) if
+// it was dialed for this request.
+func roundTrip(cc *http.ClientConn, request *http.Request) (resp *http.Response, err os.Error) {
+	if cc != nil {
+		return cc.Do(request)
+	}
+	if cc, err = dialHTTP(request.Host, request.URL.Scheme); err != nil {
+		return
+	}
+	resp, err = cc.Do(request)
+	if resp == nil || (err != nil && err != http.ErrPersistEOF) {
+		cc.Close()
+		return
+	}
+	resp.Body = &finishBody{ReadCloser: resp.Body, finished: func() { cc.Close() }}
+	return
+}
+
+// Calls finished (once) when the body has been read to the end or closed.
+type finishBody struct {
+	io.ReadCloser
+	finished func()
+	once     sync.Once
+}
+
+func (self *finishBody) Read(p []byte) (n int, err os.Error) {
+	n, err = self.ReadCloser.Read(p)
+	if err != nil {
+		self.once.Do(self.finished)
+	}
+	return
+}
+
+func (self *finishBody) Close() (err os.Error) {
+	err = self.ReadCloser.Close()
+	self.once.Do(self.finished)
+	return
+}
+
+// Wraps the body of a response while its handler runs.  Unless the handler
+// hands the response on (see keepBody), what's left of the body is read and
+// the body closed once the handler returns.
+type handlerBody struct {
+	io.ReadCloser
+	kept   bool
+	closed bool
+}
+
+func (self *handlerBody) Close() os.Error {
+	self.closed = true
+	return self.ReadCloser.Close()
+}
+
+// Called by a response handler that passes resp (and the job of closing its
+// body) on to its caller, before anything replaces resp.Body.
+func keepBody(resp *http.Response) {
+	if hb, ok := resp.Body.(*handlerBody); ok {
+		hb.kept = true
+	}
+}
+
+func (self Client) roundTrip(cc *http.ClientConn, request *http.Request) (resp *http.Response, err os.Error) {
+	if self.Context != nil {
+		return contextRoundTrip(self.Context, cc, request)
+	}
+	return roundTrip(cc, request)
+}
 
-func dispatchRequest(cc *http.ClientConn, request *http.Request, rc map[int]func(*http.Response) os.Error) (err os.Error) {
-  if cc == nil {
-    cc, err = dialHTTP(request.Host, request.URL.Scheme)
-  }
-  if err != nil {
-    return
-  }
-  resp, err := cc.Do(request)
-  if resp !=	nil && (err == nil || err == http.ErrPersistEOF ) {
-    if rcf, ok := rc[resp.StatusCode]; ok {
-      return rcf(resp)
-    }
-    if rcf, ok := rc[-1]; ok {
-      return rcf(resp)
-    }
-		// If you don't handle your errors, you won't be able to spot a persistant connection closing!
-    err = os.NewError("No response handler for code")
-  }
-  return
+func dispatchRequest(c Client, cc *http.ClientConn, request *http.Request, rc map[int]func(*http.Response) os.Error) (err os.Error) {
+	if err = c.canceled(); err != nil {
+		return
+	}
+	var resp *http.Response
+	if c.Cassette != nil {
+		resp, err = c.Cassette.roundTrip(c, cc, request)
+	} else {
+		resp, err = c.roundTrip(cc, request)
+	}
+	if resp != nil && (err == nil || err == http.ErrPersistEOF) {
+		hb := &handlerBody{ReadCloser: resp.Body}
+		resp.Body = hb
+		err = handleResponse(resp, rc)
+		if (!hb.kept || err != nil) && !hb.closed {
+			if !hb.kept {
+				// so the connection can be reused (or let go)
+				io.Copy(ioutil.Discard, hb)
+			}
+			hb.Close()
+		}
+	}
+	if cerr := c.canceled(); cerr != nil {
+		err = cerr
+	}
+	return
 }
 
+func handleResponse(resp *http.Response, rc map[int]func(*http.Response) os.Error) (err os.Error) {
+	if rcf, ok := rc[resp.StatusCode]; ok {
+		return rcf(resp)
+	}
+	if rcf, ok := rc[-1]; ok {
+		return rcf(resp)
+	}
+	// If you don't handle your errors, you won't be able to spot a persistant connection closing!
+	return os.NewError("No response handler for code")
+}
@@ -0,0 +1,188 @@
+package riak
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"http"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// Values written through an Encryptor are sealed with AES-GCM before they leave
+// the client.  The key id and nonce are stored as usermeta so that values written
+// with an older key can still be read after rotation.  Other headers (content-type,
+// links, secondary indexes) are stored as given.
+//
+// The ciphertext is bound to its bucket and key (they're the GCM additional
+// data), so a value copied or moved to another key won't decrypt.  In
+// particular, Migrate with a KeyTransform or a different destination bucket
+// copies encrypted values that can no longer be read; decrypt and re-encrypt
+// them instead.
+
+const encryptionKeyMeta = "X-Riak-Meta-Riak-Key-Id"
+const encryptionNonceMeta = "X-Riak-Meta-Riak-Nonce"
+
+var ErrUnknownKeyId = os.NewError("Unknown encryption key id")
+var ErrNotEncrypted = os.NewError("Value is not encrypted")
+
+// A KeyProvider supplies per-bucket AES keys (16, 24 or 32 bytes).
+type KeyProvider interface {
+	// The key (and its id) new values in bucket should be encrypted with.
+	CurrentKey(bucket string) (id string, key []byte, err os.Error)
+	// The key with the given id, for reading values written before a rotation.
+	// Should return ErrUnknownKeyId if the key is not known.
+	Key(bucket, id string) (key []byte, err os.Error)
+}
+
+// A KeyProvider backed by a map; useful for tests and small deployments.
+// Keys are looked up by bucket, then id; Current maps buckets to the id used for writes.
+type StaticKeys struct {
+	Keys    map[string]map[string][]byte
+	Current map[string]string
+}
+
+func (self StaticKeys) CurrentKey(bucket string) (id string, key []byte, err os.Error) {
+	id = self.Current[bucket]
+	key, err = self.Key(bucket, id)
+	return
+}
+
+func (self StaticKeys) Key(bucket, id string) (key []byte, err os.Error) {
+	key, ok := self.Keys[bucket][id]
+	if !ok {
+		err = ErrUnknownKeyId
+	}
+	return
+}
+
+type Encryptor struct {
+	Client Client
+	Keys   KeyProvider
+	// If set, values that were stored without encryption are returned as-is
+	// instead of failing with ErrNotEncrypted (useful while migrating a bucket).
+	AllowPlaintext bool
+}
+
+// The additional authenticated data for values stored at bucket/key.  Each is
+// length-prefixed, since either may contain any separator.
+func encryptionData(bucket, key string) []byte {
+	return []byte(strconv.Itoa(len(bucket)) + ":" + bucket + strconv.Itoa(len(key)) + ":" + key)
+}
+
+// Returns the sealed body, and a copy of hdrs with the key id and nonce added.
+func (self Encryptor) encrypt(bucket, key string, body []byte, hdrs http.Header) (out []byte, outHdrs http.Header, err os.Error) {
+	id, secret, err := self.Keys.CurrentKey(bucket)
+	if err != nil {
+		return
+	}
+	g, err := newGCM(secret)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, gcmNonceSize)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	out = g.Seal(nonce, body, encryptionData(bucket, key))
+	outHdrs = copyHeader(hdrs)
+	if outHdrs == nil {
+		outHdrs = http.Header{}
+	}
+	outHdrs.Set(encryptionKeyMeta, id)
+	outHdrs.Set(encryptionNonceMeta, base64.StdEncoding.EncodeToString(nonce))
+	return
+}
+
+// Replaces the body of resp with its decrypted value.
+func (self Encryptor) decryptResponse(bucket, key string, resp *http.Response) (err os.Error) {
+	id := resp.Header.Get(encryptionKeyMeta)
+	if id == "" {
+		if !self.AllowPlaintext {
+			err = ErrNotEncrypted
+		}
+		return
+	}
+	secret, err := self.Keys.Key(bucket, id)
+	if err != nil {
+		return
+	}
+	nonce, err := base64.StdEncoding.DecodeString(resp.Header.Get(encryptionNonceMeta))
+	if err != nil {
+		return
+	}
+	if len(nonce) != gcmNonceSize {
+		return ErrDecryptFailed
+	}
+	g, err := newGCM(secret)
+	if err != nil {
+		return
+	}
+	sealed, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return
+	}
+	body, err := g.Open(nonce, sealed, encryptionData(bucket, key))
+	if err != nil {
+		return
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Del(encryptionKeyMeta)
+	resp.Header.Del(encryptionNonceMeta)
+	return
+}
+
+// As PutItem, but the body is encrypted with the bucket's current key.
+func (self Encryptor) PutItem(bucket, key string, body []byte, hdrs http.Header, parms http.Values, cc *http.ClientConn) (err os.Error) {
+	if hdrs == nil {
+		hdrs = http.Header{"Content-Type": []string{"application/binary"}}
+	}
+	body, hdrs, err = self.encrypt(bucket, key, body, hdrs)
+	if err == nil {
+		err = PutItem(self.Client, bucket, key, body, hdrs, parms, cc)
+	}
+	return
+}
+
+// As GetItem, but the body of the response is decrypted.
+func (self Encryptor) GetItem(bucket, key string, hdrs http.Header, parms http.Values, cc *http.ClientConn) (resp *http.Response, err os.Error) {
+	resp, err = GetItem(self.Client, bucket, key, hdrs, parms, cc)
+	if err == nil {
+		err = self.decryptResponse(bucket, key, resp)
+	}
+	return
+}
+
+// As GetMultiItem, but each sibling is decrypted before being sent on respch.
+// Siblings that fail to decrypt are not sent; the first such error is returned.
+func (self Encryptor) GetMultiItem(bucket, key string, hdrs http.Header, parms http.Values, respch chan<- *http.Response, cc *http.ClientConn) (err os.Error) {
+	inner := make(chan *http.Response)
+	done := make(chan os.Error)
+	go func() {
+		var derr os.Error
+		for resp := range inner {
+			if e := self.decryptResponse(bucket, key, resp); e != nil {
+				if derr == nil {
+					derr = e
+				}
+				continue
+			}
+			if e := self.Client.sendResponse(respch, resp); e != nil && derr == nil {
+				derr = e
+			}
+		}
+		close(respch)
+		done <- derr
+	}()
+	err = GetMultiItem(self.Client, bucket, key, hdrs, parms, inner, cc)
+	derr := <-done
+	if err == nil {
+		err = derr
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package riak
+
+import (
+	"bytes"
+	"encoding/hex"
+	"http"
+	"io/ioutil"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	fatalIf(t, err != nil, "Bad hex in test: %v", err)
+	return b
+}
+
+// From the NIST GCM spec: test cases 2, 3 (multi-block), 4 (with additional
+// data, partial final block) and 16 (AES-256).
+var gcmVectors = []struct {
+	key, nonce, plaintext, data, sealed string
+}{
+	{
+		"00000000000000000000000000000000", "000000000000000000000000",
+		"00000000000000000000000000000000", "",
+		"0388dace60b6a392f328c2b971b2fe78" + "ab6e47d42cec13bdf53a67b21257bddf",
+	},
+	{
+		"feffe9928665731c6d6a8f9467308308", "cafebabefacedbaddecaf888",
+		"d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a72" +
+			"1c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b391aafd255",
+		"",
+		"42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e" +
+			"21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091473f5985" +
+			"4d5c2af327cd64a62cf35abd2ba6fab4",
+	},
+	{
+		"feffe9928665731c6d6a8f9467308308", "cafebabefacedbaddecaf888",
+		"d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a72" +
+			"1c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39",
+		"feedfacedeadbeeffeedfacedeadbeefabaddad2",
+		"42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e" +
+			"21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091" +
+			"5bc94fbc3221a5db94fae95ae7121a47",
+	},
+	{
+		"feffe9928665731c6d6a8f9467308308feffe9928665731c6d6a8f9467308308", "cafebabefacedbaddecaf888",
+		"d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a72" +
+			"1c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39",
+		"feedfacedeadbeeffeedfacedeadbeefabaddad2",
+		"522dc1f099567d07f47f37a32a84427d643a8cdcbfe5c0c97598a2bd2555d1aa" +
+			"8cb08e48590dbb3da7b08b1056828838c5f61e6393ba7a0abcc9f662" +
+			"76fc6ece0f4e1768cddf8853bb2d551b",
+	},
+}
+
+func TestGCMVectors(t *testing.T) {
+	for i, v := range gcmVectors {
+		g, err := newGCM(decodeHex(t, v.key))
+		fatalIf(t, err != nil, "Couldn't create gcm: %v", err)
+		nonce, pt, data := decodeHex(t, v.nonce), decodeHex(t, v.plaintext), decodeHex(t, v.data)
+		out := g.Seal(nonce, pt, data)
+		fatalIf(t, hex.EncodeToString(out) != v.sealed, "Vector %d: wrong ciphertext: %x", i, out)
+		opened, err := g.Open(nonce, out, data)
+		fatalIf(t, err != nil || !bytes.Equal(opened, pt), "Vector %d: couldn't open: %v", i, err)
+		out[0] ^= 1
+		_, err = g.Open(nonce, out, data)
+		fatalIf(t, err != ErrDecryptFailed, "Vector %d: tampered ciphertext opened: %v", i, err)
+		if len(data) > 0 {
+			out[0] ^= 1
+			_, err = g.Open(nonce, out, data[1:])
+			fatalIf(t, err != ErrDecryptFailed, "Vector %d: opened with the wrong data: %v", i, err)
+		}
+	}
+}
+
+func testEncryptor(t *testing.T) Encryptor {
+	return Encryptor{
+		Client: testClient(t),
+		Keys: StaticKeys{
+			Keys: map[string]map[string][]byte{
+				TESTING_BUCKET: {
+					"old": bytes.Repeat([]byte{1}, 16),
+					"new": bytes.Repeat([]byte{2}, 32),
+				},
+			},
+			Current: map[string]string{TESTING_BUCKET: "new"},
+		},
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	e := testEncryptor(t)
+	hdrs := http.Header{"X-Riak-Index-Email_bin": []string{"test@example.com"}}
+	body, out, err := e.encrypt(TESTING_BUCKET, "key", []byte("hello world"), hdrs)
+	fatalIf(t, err != nil, "Couldn't encrypt: %v", err)
+	fatalIf(t, out.Get(encryptionKeyMeta) != "new", "Wrong key id: %s", out.Get(encryptionKeyMeta))
+	fatalIf(t, out.Get("X-Riak-Index-Email_bin") != "test@example.com", "Index header was modified")
+	fatalIf(t, hdrs.Get(encryptionKeyMeta) != "", "Caller's headers modified: %v", hdrs)
+	fatalIf(t, bytes.Contains(body, []byte("hello world")), "Body wasn't encrypted")
+
+	resp := &http.Response{Header: out, Body: ioutil.NopCloser(bytes.NewBuffer(body))}
+	err = e.decryptResponse(TESTING_BUCKET, "key", resp)
+	fatalIf(t, err != nil, "Couldn't decrypt: %v", err)
+	out, _ := ioutil.ReadAll(resp.Body)
+	fatalIf(t, string(out) != "hello world", "Wrong plaintext: %s", out)
+}
+
+func TestDecryptRotatedKey(t *testing.T) {
+	e := testEncryptor(t)
+	e.Keys.(StaticKeys).Current[TESTING_BUCKET] = "old"
+	body, hdrs, err := e.encrypt(TESTING_BUCKET, "key", []byte("hello world"), nil)
+	fatalIf(t, err != nil, "Couldn't encrypt: %v", err)
+	e.Keys.(StaticKeys).Current[TESTING_BUCKET] = "new"
+	resp := &http.Response{Header: hdrs, Body: ioutil.NopCloser(bytes.NewBuffer(body))}
+	err = e.decryptResponse(TESTING_BUCKET, "key", resp)
+	fatalIf(t, err != nil, "Couldn't decrypt with old key: %v", err)
+}
+
+func TestDecryptPlaintext(t *testing.T) {
+	e := testEncryptor(t)
+	resp := &http.Response{Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewBufferString("hello world"))}
+	err := e.decryptResponse(TESTING_BUCKET, "key", resp)
+	fatalIf(t, err != ErrNotEncrypted, "Expected ErrNotEncrypted, got %v", err)
+	e.AllowPlaintext = true
+	err = e.decryptResponse(TESTING_BUCKET, "key", resp)
+	fatalIf(t, err != nil, "Unexpected error with AllowPlaintext: %v", err)
+}
+
+func TestDecryptOtherKey(t *testing.T) {
+	e := testEncryptor(t)
+	body, hdrs, err := e.encrypt(TESTING_BUCKET, "key", []byte("hello world"), nil)
+	fatalIf(t, err != nil, "Couldn't encrypt: %v", err)
+	resp := &http.Response{Header: hdrs, Body: ioutil.NopCloser(bytes.NewBuffer(body))}
+	err = e.decryptResponse(TESTING_BUCKET, "other-key", resp)
+	fatalIf(t, err != ErrDecryptFailed, "Value moved to another key decrypted: %v", err)
+}
+
+func TestEncryptionDataUnambiguous(t *testing.T) {
+	fatalIf(t, bytes.Equal(encryptionData("a/b", "c"), encryptionData("a", "b/c")), "a/b c and a b/c share additional data")
+}
@@ -0,0 +1,60 @@
+package riak
+
+import (
+	"http"
+	"strings"
+)
+
+// Bucket names and keys may contain anything, including "/", "%", "+" and
+// spaces, so they're percent-encoded as path segments.  Riak decodes them
+// (with http_url_encoding on, the default since 1.0) and encodes them again,
+// form-style, in key and bucket listings and Link headers.
+
+func shouldEscape(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return false
+	case c == '-', c == '_', c == '.', c == '~':
+		return false
+	}
+	return true
+}
+
+// Escapes s for use as a single path segment.
+func escapeSegment(s string) string {
+	// "." and ".." would be removed by path.Join (and by any proxy on the way)
+	if s == "." || s == ".." {
+		return strings.Repeat("%2E", len(s))
+	}
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if shouldEscape(s[i]) {
+			n++
+		}
+	}
+	if n == 0 {
+		return s
+	}
+	const hex = "0123456789ABCDEF"
+	out := make([]byte, 0, len(s)+2*n)
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; shouldEscape(c) {
+			out = append(out, '%', hex[c>>4], hex[c&15])
+		} else {
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
+
+// Decodes a key or bucket name from a listing or Link header; anything that
+// isn't validly encoded is returned as is.
+func unescapeName(s string) string {
+	if !strings.ContainsAny(s, "%+") {
+		return s
+	}
+	if u, err := http.URLUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
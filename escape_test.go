@@ -0,0 +1,50 @@
+package riak
+
+import (
+	"path"
+	"testing"
+)
+
+var escapedSegments = map[string]string{
+	"plain-key_1.txt~": "plain-key_1.txt~",
+	"a/b":              "a%2Fb",
+	"a b+c":            "a%20b%2Bc",
+	"100%":             "100%25",
+	".":                "%2E",
+	"..":               "%2E%2E",
+	"...":              "...",
+	"é":                "%C3%A9",
+}
+
+func TestEscapeSegment(t *testing.T) {
+	for in, out := range escapedSegments {
+		fatalIf(t, escapeSegment(in) != out, "escapeSegment(%q) = %q, wanted %q", in, escapeSegment(in), out)
+	}
+}
+
+// riaktest's TestTrickyKeys round-trips riaktest.TrickyNames through a server.
+func TestUnescapeName(t *testing.T) {
+	for k := range escapedSegments {
+		fatalIf(t, unescapeName(escapeSegment(k)) != k, "%q didn't survive escaping: %q", k, unescapeName(escapeSegment(k)))
+	}
+	fatalIf(t, unescapeName("a+b%2Bc") != "a b+c", "Form encoding not decoded: %q", unescapeName("a+b%2Bc"))
+	fatalIf(t, unescapeName("bad%zz") != "bad%zz", "Invalid encoding should be left alone: %q", unescapeName("bad%zz"))
+}
+
+func TestEscapedKeyRequest(t *testing.T) {
+	c := testClient(t)
+	req := getItemRequest(c, "my bucket", "../a/b?c", nil, nil)
+	expPath := path.Join(TESTING_RIAK.Path, "riak", "my%20bucket", "..%2Fa%2Fb%3Fc")
+	fatalIf(t, req.URL.Path != expPath, "Unexpected path: %s [wanted: %s]", req.URL.Path, expPath)
+	fatalIf(t, req.RawURL != expPath, "Unexpected request URL: %s", req.RawURL)
+	req = getItemRequest(c, "b", "k", nil, map[string][]string{"r": []string{"1"}})
+	fatalIf(t, req.RawURL != "/riak/b/k?r=1", "Query missing from request URL: %s", req.RawURL)
+}
+
+func TestParseEscapedLinks(t *testing.T) {
+	links := parseLinks(`</riak/my+bucket/a%2Fb>; riaktag="x"`)
+	fatalIf(t, len(links["x"]) != 1 || !sameLink(links["x"][0], Link{"my bucket", "a/b"}), "Wrong links: %v", links)
+	c := testClient(t)
+	fatalIf(t, c.linkHeader(Link{"my bucket", "a/b"}, "x") != `</riak/my%20bucket/a%2Fb>; riaktag="x"`,
+		"Link not escaped: %s", c.linkHeader(Link{"my bucket", "a/b"}, "x"))
+}
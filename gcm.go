@@ -0,0 +1,120 @@
+package riak
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"os"
+)
+
+// A minimal AES-GCM (NIST SP 800-38D) implementation; the standard library
+// doesn't provide one.  Only 96-bit nonces and 128-bit tags are supported.
+
+const gcmNonceSize = 12
+const gcmTagSize = 16
+
+var ErrDecryptFailed = os.NewError("Message authentication failed")
+
+type gcm struct {
+	block  cipher.Block
+	hi, lo uint64 // H, the hash subkey
+}
+
+func newGCM(key []byte) (g *gcm, err os.Error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	h := make([]byte, aes.BlockSize)
+	block.Encrypt(h, h)
+	g = &gcm{
+		block: block,
+		hi:    binary.BigEndian.Uint64(h[0:8]),
+		lo:    binary.BigEndian.Uint64(h[8:16]),
+	}
+	return
+}
+
+// multiplies (yhi, ylo) by H in GF(2^128).  Secret bits only ever select
+// values through masks (-bit is all ones or all zeros), never branches, so
+// the running time doesn't depend on them.
+func (self *gcm) mul(yhi, ylo uint64) (zhi, zlo uint64) {
+	vhi, vlo := self.hi, self.lo
+	for i := 0; i < 128; i++ {
+		var bit uint64
+		if i < 64 {
+			bit = (yhi >> uint(63-i)) & 1
+		} else {
+			bit = (ylo >> uint(127-i)) & 1
+		}
+		mask := -bit
+		zhi ^= vhi & mask
+		zlo ^= vlo & mask
+		mask = -(vlo & 1)
+		vlo = vlo>>1 | vhi<<63
+		vhi >>= 1
+		vhi ^= 0xe100000000000000 & mask
+	}
+	return
+}
+
+func (self *gcm) ghashUpdate(yhi, ylo uint64, data []byte) (uint64, uint64) {
+	for len(data) > 0 {
+		block := make([]byte, 16)
+		n := copy(block, data)
+		data = data[n:]
+		yhi ^= binary.BigEndian.Uint64(block[0:8])
+		ylo ^= binary.BigEndian.Uint64(block[8:16])
+		yhi, ylo = self.mul(yhi, ylo)
+	}
+	return yhi, ylo
+}
+
+func (self *gcm) tag(nonce, ciphertext, data []byte) []byte {
+	var yhi, ylo uint64
+	yhi, ylo = self.ghashUpdate(yhi, ylo, data)
+	yhi, ylo = self.ghashUpdate(yhi, ylo, ciphertext)
+	yhi ^= uint64(len(data)) * 8
+	ylo ^= uint64(len(ciphertext)) * 8
+	yhi, ylo = self.mul(yhi, ylo)
+
+	tag := make([]byte, gcmTagSize)
+	binary.BigEndian.PutUint64(tag[0:8], yhi)
+	binary.BigEndian.PutUint64(tag[8:16], ylo)
+	j0 := self.counter(nonce, 1)
+	self.block.Encrypt(j0, j0)
+	for i := range tag {
+		tag[i] ^= j0[i]
+	}
+	return tag
+}
+
+func (self *gcm) counter(nonce []byte, n uint32) (out []byte) {
+	out = make([]byte, aes.BlockSize)
+	copy(out, nonce)
+	binary.BigEndian.PutUint32(out[12:], n)
+	return
+}
+
+// Returns ciphertext || tag
+func (self *gcm) Seal(nonce, plaintext, data []byte) (out []byte) {
+	out = make([]byte, len(plaintext), len(plaintext)+gcmTagSize)
+	cipher.NewCTR(self.block, self.counter(nonce, 2)).XORKeyStream(out, plaintext)
+	out = append(out, self.tag(nonce, out, data)...)
+	return
+}
+
+func (self *gcm) Open(nonce, sealed, data []byte) (out []byte, err os.Error) {
+	if len(sealed) < gcmTagSize {
+		return nil, ErrDecryptFailed
+	}
+	ciphertext := sealed[:len(sealed)-gcmTagSize]
+	tag := self.tag(nonce, ciphertext, data)
+	if subtle.ConstantTimeCompare(tag, sealed[len(ciphertext):]) != 1 {
+		return nil, ErrDecryptFailed
+	}
+	out = make([]byte, len(ciphertext))
+	cipher.NewCTR(self.block, self.counter(nonce, 2)).XORKeyStream(out, ciphertext)
+	return
+}
@@ -0,0 +1,61 @@
+package riak
+
+import (
+	"http"
+	"os"
+)
+
+// KV is the set of operations supported by both Client and riaktest.MemoryKV,
+// so code built on this package can substitute a fake in its tests.
+//
+// The Client methods always dial a new connection; use the package functions
+// directly to reuse an *http.ClientConn.
+type KV interface {
+	Ping() os.Error
+	GetBucket(name string, getprops, getkeys bool) (BucketDetails, os.Error)
+	SetBucket(name string, props Properties) os.Error
+	ListBuckets() ([]string, os.Error)
+	// outch is closed when listing completes
+	ListKeys(bucket string, outch chan<- string) os.Error
+	Get(bucket, key string, hdrs http.Header, parms http.Values) (*http.Response, os.Error)
+	// respch is closed when all siblings have been sent
+	GetSiblings(bucket, key string, hdrs http.Header, parms http.Values, respch chan<- *http.Response) os.Error
+	Put(bucket, key string, body []byte, hdrs http.Header, parms http.Values) os.Error
+	Delete(bucket, key string, parms http.Values) os.Error
+}
+
+func (self Client) Ping() os.Error {
+	return Ping(self, nil)
+}
+
+func (self Client) GetBucket(name string, getprops, getkeys bool) (BucketDetails, os.Error) {
+	return GetBucket(self, name, getprops, getkeys, nil)
+}
+
+func (self Client) SetBucket(name string, props Properties) os.Error {
+	return SetBucket(self, name, props, nil)
+}
+
+func (self Client) ListBuckets() ([]string, os.Error) {
+	return ListBuckets(self, nil)
+}
+
+func (self Client) ListKeys(bucket string, outch chan<- string) os.Error {
+	return ListKeys(self, bucket, outch, nil)
+}
+
+func (self Client) Get(bucket, key string, hdrs http.Header, parms http.Values) (*http.Response, os.Error) {
+	return GetItem(self, bucket, key, hdrs, parms, nil)
+}
+
+func (self Client) GetSiblings(bucket, key string, hdrs http.Header, parms http.Values, respch chan<- *http.Response) os.Error {
+	return GetMultiItem(self, bucket, key, hdrs, parms, respch, nil)
+}
+
+func (self Client) Put(bucket, key string, body []byte, hdrs http.Header, parms http.Values) os.Error {
+	return PutItem(self, bucket, key, body, hdrs, parms, nil)
+}
+
+func (self Client) Delete(bucket, key string, parms http.Values) os.Error {
+	return DeleteItem(self, bucket, key, parms, nil)
+}
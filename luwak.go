@@ -0,0 +1,118 @@
+package riak
+
+import (
+	"fmt"
+	"http"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Luwak is the large-file interface exposed by older riak clusters at /luwak.
+// See http://wiki.basho.com/Luwak.html for details of the protocol.
+
+var ErrRangeNotSatisfiable = os.NewError("Requested range not satisfiable")
+
+func (self Client) luwakPath(key string) string {
+	return path.Join(self.resourcePath(ResourceLuwak), escapeSegment(key))
+}
+
+// If length is negative, the body will be sent chunked (this allows streaming
+// uploads of unknown size).
+func luwakPutRequest(c Client, key string, body io.Reader, length int64, hdrs http.Header, parms http.Values) (req *http.Request) {
+	hdrs = copyHeader(hdrs)
+	if hdrs == nil {
+		hdrs = http.Header{}
+	}
+	if hdrs.Get("Content-Type") == "" {
+		hdrs.Set("Content-Type", "application/octet-stream")
+	}
+	req = c.request("PUT", c.luwakPath(key), hdrs, parms)
+	if rc, ok := body.(io.ReadCloser); ok {
+		req.Body = rc
+	} else {
+		req.Body = ioutil.NopCloser(body)
+	}
+	if length < 0 {
+		req.TransferEncoding = []string{"chunked"}
+	} else {
+		req.ContentLength = length
+	}
+	return
+}
+
+// for hdrs, you can include any header; X-Riak-Meta-* headers are stored alongside the file.
+// body will be read until EOF (or length bytes if length is not negative).
+func LuwakPut(c Client, key string, body io.Reader, length int64, hdrs http.Header, parms http.Values, cc *http.ClientConn) (err os.Error) {
+	req := luwakPutRequest(c, key, body, length, hdrs, parms)
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+		-1:  debugFailf(os.Stdout, true, "LuwakPut failed:"+req.URL.String()),
+		400: func(*http.Response) os.Error { return ErrBadRequest },
+		503: func(*http.Response) os.Error { return ErrServiceUnavailable },
+		204: okf,
+	})
+	return
+}
+
+// for hdrs, only include headers listed as optional from 'http://wiki.basho.com/HTTP-Fetch-Object.html'
+func luwakGetRequest(c Client, key string, hdrs http.Header, parms http.Values) (req *http.Request) {
+	req = c.request("GET", c.luwakPath(key), hdrs, parms)
+	return
+}
+
+// If the caller set a Range header, the response may be either a 200 (the server
+// ignored the range) or a 206 (partial content); check resp.StatusCode if it matters.
+func LuwakGet(c Client, key string, hdrs http.Header, parms http.Values, cc *http.ClientConn) (resp *http.Response, err os.Error) {
+	req := luwakGetRequest(c, key, hdrs, parms)
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+		-1:  debugFailf(os.Stdout, true, "LuwakGet failed"+req.URL.String()),
+		404: func(*http.Response) os.Error { return ErrUnknownKey },
+		416: func(*http.Response) os.Error { return ErrRangeNotSatisfiable },
+		503: func(*http.Response) os.Error { return ErrServiceUnavailable },
+		200: func(rresp *http.Response) (err os.Error) {
+			keepBody(rresp)
+			resp = rresp
+			return
+		},
+		206: func(rresp *http.Response) (err os.Error) {
+			keepBody(rresp)
+			resp = rresp
+			return
+		},
+	})
+	return
+}
+
+// Fetches bytes [first, last] (inclusive) of the file; if last is negative, the
+// file is read from first to the end.
+func LuwakGetRange(c Client, key string, first, last int64, hdrs http.Header, parms http.Values, cc *http.ClientConn) (resp *http.Response, err os.Error) {
+	hdrs = copyHeader(hdrs)
+	if hdrs == nil {
+		hdrs = http.Header{}
+	}
+	hdrs.Set("Range", luwakRange(first, last))
+	return LuwakGet(c, key, hdrs, parms, cc)
+}
+
+func luwakRange(first, last int64) string {
+	if last < 0 {
+		return fmt.Sprintf("bytes=%d-", first)
+	}
+	return fmt.Sprintf("bytes=%d-%d", first, last)
+}
+
+func luwakDeleteRequest(c Client, key string, parms http.Values) (req *http.Request) {
+	req = c.request("DELETE", c.luwakPath(key), nil, parms)
+	return
+}
+
+func LuwakDelete(c Client, key string, parms http.Values, cc *http.ClientConn) (err os.Error) {
+	req := luwakDeleteRequest(c, key, parms)
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+		-1:  debugFailf(os.Stdout, true, "LuwakDelete failed"),
+		404: func(*http.Response) os.Error { return ErrUnknownKey },
+		204: okf,
+	})
+	return
+}
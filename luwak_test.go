@@ -0,0 +1,47 @@
+package riak
+
+import "testing"
+import "path"
+import "bytes"
+import "http"
+
+func TestLuwakPutRequest(t *testing.T) {
+	expPath := path.Join(TESTING_RIAK.Path, "luwak", "TestLuwakPutRequest")
+	c := testClient(t)
+	req := luwakPutRequest(c, "TestLuwakPutRequest", bytes.NewBufferString("hello world"), 11, nil, nil)
+	fatalIf(t, req == nil, "Got a nil request back!")
+	fatalIf(t, req.Method != "PUT", "Unexpected method: %s", req.Method)
+	fatalIf(t, req.URL.Path != expPath, "Unexpected path: %s", req.URL.Path)
+	fatalIf(t, req.ContentLength != 11, "Got a bad content length: %d", req.ContentLength)
+	fatalIf(t, req.Header.Get("Content-Type") == "", "Content-type must be set: (got none)")
+	fatalIf(t, req.Header.Get("X-Riak-ClientId") == "", "ClientId must be set: (got none)")
+}
+
+func TestLuwakStreamingPutRequest(t *testing.T) {
+	c := testClient(t)
+	req := luwakPutRequest(c, "TestLuwakStreamingPutRequest", bytes.NewBufferString("hello world"), -1, nil, nil)
+	fatalIf(t, req == nil, "Got a nil request back!")
+	fatalIf(t, len(req.TransferEncoding) != 1 || req.TransferEncoding[0] != "chunked", "Expected a chunked request (got %v)", req.TransferEncoding)
+}
+
+func TestLuwakRange(t *testing.T) {
+	fatalIf(t, luwakRange(0, 99) != "bytes=0-99", "Bad range: %s", luwakRange(0, 99))
+	fatalIf(t, luwakRange(100, -1) != "bytes=100-", "Bad open range: %s", luwakRange(100, -1))
+}
+
+func TestLuwakDeleteRequest(t *testing.T) {
+	expPath := path.Join(TESTING_RIAK.Path, "luwak", "TestLuwakDeleteRequest")
+	c := testClient(t)
+	req := luwakDeleteRequest(c, "TestLuwakDeleteRequest", nil)
+	fatalIf(t, req == nil, "Got a nil request back!")
+	fatalIf(t, req.Method != "DELETE", "Unexpected method: %s", req.Method)
+	fatalIf(t, req.URL.Path != expPath, "Unexpected path: %s", req.URL.Path)
+}
+
+func TestLuwakPutRequestKeepsHeaders(t *testing.T) {
+	c := testClient(t)
+	hdrs := http.Header{"X-Riak-Meta-A": []string{"1"}}
+	req := luwakPutRequest(c, "TestLuwakPutRequestKeepsHeaders", bytes.NewBufferString("hello world"), 11, hdrs, nil)
+	fatalIf(t, req.Header.Get("Content-Type") != "application/octet-stream", "No default Content-Type: %v", req.Header)
+	fatalIf(t, hdrs.Get("Content-Type") != "", "Caller's headers modified: %v", hdrs)
+}
@@ -0,0 +1,33 @@
+package riak
+
+import (
+	"bytes"
+	"http"
+	"io/ioutil"
+	"os"
+)
+
+// See http://wiki.basho.com/MapReduce.html for the format of job.
+func mapReduceRequest(c Client, job []byte, parms http.Values) (req *http.Request) {
+	hdrs := http.Header{"Content-Type": []string{"application/json"}}
+	req = c.request("POST", c.resourcePath(ResourceMapReduce), hdrs, parms)
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(job))
+	req.ContentLength = int64(len(job))
+	return
+}
+
+// Submits a (JSON) map/reduce job; the results are in the body of resp.
+func MapReduce(c Client, job []byte, parms http.Values, cc *http.ClientConn) (resp *http.Response, err os.Error) {
+	req := mapReduceRequest(c, job, parms)
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+		-1:  debugFailf(os.Stdout, true, "MapReduce failed"),
+		400: func(*http.Response) os.Error { return ErrBadRequest },
+		503: func(*http.Response) os.Error { return ErrServiceUnavailable },
+		200: func(rresp *http.Response) (err os.Error) {
+			keepBody(rresp)
+			resp = rresp
+			return
+		},
+	})
+	return
+}
@@ -0,0 +1,15 @@
+package riak
+
+import "testing"
+import "path"
+
+func TestMapReduceRequest(t *testing.T) {
+	expPath := path.Join(TESTING_RIAK.Path, "mapred")
+	c := testClient(t)
+	req := mapReduceRequest(c, []byte(`{"inputs":"bucket","query":[]}`), nil)
+	fatalIf(t, req == nil, "Got a nil request back!")
+	fatalIf(t, req.Method != "POST", "Unexpected method: %s", req.Method)
+	fatalIf(t, req.URL.Path != expPath, "Unexpected path: %s", req.URL.Path)
+	fatalIf(t, req.ContentLength <= 0, "Got a bad content length: %d", req.ContentLength)
+	fatalIf(t, req.Header.Get("Content-Type") != "application/json", "Unexpected content-type: %s", req.Header.Get("Content-Type"))
+}
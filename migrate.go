@@ -0,0 +1,234 @@
+package riak
+
+import (
+	"bufio"
+	"fmt"
+	"http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Migrate copies keys (with all their siblings, links, indexes and metadata)
+// from one bucket to another, which may be on a different cluster.
+
+var ErrVerifyFailed = os.NewError("Copied value doesn't match the source")
+
+type MigrateOptions struct {
+	// Number of concurrent copies (DefaultBatchWorkers if zero)
+	Workers int
+	// If non-nil, returns the destination key for each source key; keys for
+	// which it returns false are skipped.  (Values written by an Encryptor
+	// won't decrypt under a new key or bucket.)
+	KeyTransform func(key string) (string, bool)
+	// If non-nil, called on each sibling before it's written; the header may be
+	// modified in place.
+	ValueTransform func(key string, value []byte, hdr http.Header) ([]byte, os.Error)
+	// Copy the bucket properties before any keys
+	CopyProps bool
+	// What to do with keys that already exist at the destination
+	Policy RestorePolicy
+	// Re-read each key from the destination after writing it
+	Verify bool
+	// If non-nil, keys already in the log are skipped and copied keys are added to it
+	Log *MigrateLog
+	// If non-nil, errors copying individual keys are reported here and the
+	// migration continues; otherwise the first error stops it.
+	OnError func(key string, err os.Error)
+}
+
+type MigrateStats struct {
+	Listed   int
+	Copied   int
+	Skipped  int
+	Verified int
+	Failed   int
+}
+
+// A resumable record of the source keys that have been copied: one key per line.
+type MigrateLog struct {
+	lock sync.Mutex
+	out  *os.File
+	done map[string]bool
+}
+
+// Opens (creating if necessary) the progress log at name.
+func OpenMigrateLog(name string) (ml *MigrateLog, err os.Error) {
+	ml = &MigrateLog{done: map[string]bool{}}
+	if f, oerr := os.Open(name); oerr == nil {
+		r := bufio.NewReader(f)
+		for line, rerr := r.ReadString('\n'); rerr == nil; line, rerr = r.ReadString('\n') {
+			ml.done[strings.TrimRight(line, "\n")] = true
+		}
+		f.Close()
+	}
+	ml.out, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	return
+}
+
+func (self *MigrateLog) Done(key string) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.done[key]
+}
+
+func (self *MigrateLog) record(key string) (err os.Error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.done[key] = true
+	if self.out != nil {
+		_, err = self.out.WriteString(key + "\n")
+	}
+	return
+}
+
+func (self *MigrateLog) Close() os.Error {
+	if self.out == nil {
+		return nil
+	}
+	return self.out.Close()
+}
+
+func siblingValues(rec BackupRecord) (out map[string]bool) {
+	out = map[string]bool{}
+	for _, s := range rec.Siblings {
+		out[string(s.Value)] = true
+	}
+	return
+}
+
+// Checks that every sibling in want is at bucket/key on c.
+func verifyRecord(c Client, want BackupRecord) (err os.Error) {
+	got, err := FetchRecord(c, want.Bucket, want.Key)
+	if err != nil {
+		return
+	}
+	// with RestoreKeepVclock the destination may legitimately hold other
+	// siblings as well, so only the written values are checked for.
+	gv := siblingValues(got)
+	for w := range siblingValues(want) {
+		if !gv[w] {
+			return ErrVerifyFailed
+		}
+	}
+	return
+}
+
+// Copies a single key from src/srcBucket to dst/dstBucket.  copied is false if
+// the key already existed and opts.Policy is RestoreSkipExisting.
+func MigrateKey(src Client, srcBucket string, dst Client, dstBucket, key string, opts MigrateOptions) (copied bool, err os.Error) {
+	rec, err := FetchRecord(src, srcBucket, key)
+	if err != nil {
+		return
+	}
+	rec.Bucket = dstBucket
+	if opts.KeyTransform != nil {
+		var ok bool
+		if rec.Key, ok = opts.KeyTransform(key); !ok {
+			return
+		}
+	}
+	for i := range rec.Siblings {
+		s := &rec.Siblings[i]
+		if opts.ValueTransform != nil {
+			if s.Value, err = opts.ValueTransform(key, s.Value, s.Header); err != nil {
+				return
+			}
+		}
+	}
+	skipped, err := RestoreRecord(dst, rec, opts.Policy)
+	if err != nil || skipped {
+		return
+	}
+	copied = true
+	if opts.Verify {
+		err = verifyRecord(dst, rec)
+	}
+	return
+}
+
+// Copies every key in srcBucket to dstBucket.
+func Migrate(src Client, srcBucket string, dst Client, dstBucket string, opts MigrateOptions) (stats MigrateStats, err os.Error) {
+	if opts.CopyProps {
+		var bd BucketDetails
+		if bd, err = GetBucket(src, srcBucket, true, false, nil); err != nil {
+			return
+		}
+		if err = SetBucket(dst, dstBucket, bd.Props, nil); err != nil {
+			return
+		}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultBatchWorkers
+	}
+	lock := &sync.Mutex{}
+	var firstErr os.Error
+	inc := func(field *int) {
+		lock.Lock()
+		*field++
+		lock.Unlock()
+	}
+	fail := func(key string, kerr os.Error) {
+		inc(&stats.Failed)
+		if opts.OnError != nil {
+			opts.OnError(key, kerr)
+			return
+		}
+		lock.Lock()
+		if firstErr == nil {
+			firstErr = os.NewError(fmt.Sprintf("%s: %v", key, kerr))
+		}
+		lock.Unlock()
+	}
+	stopped := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return firstErr != nil
+	}
+
+	keys := make(chan string, 256)
+	listErr := make(chan os.Error, 1)
+	go func() { listErr <- ListKeys(src, srcBucket, keys, nil) }()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range keys {
+				inc(&stats.Listed)
+				if stopped() || (opts.Log != nil && opts.Log.Done(k)) {
+					inc(&stats.Skipped)
+					continue
+				}
+				copied, kerr := MigrateKey(src, srcBucket, dst, dstBucket, k, opts)
+				switch {
+				case kerr == ErrUnknownKey:
+					// deleted since it was listed
+					inc(&stats.Skipped)
+				case kerr != nil:
+					fail(k, kerr)
+					continue
+				case !copied:
+					inc(&stats.Skipped)
+				default:
+					inc(&stats.Copied)
+					if opts.Verify {
+						inc(&stats.Verified)
+					}
+				}
+				if opts.Log != nil {
+					if lerr := opts.Log.record(k); lerr != nil {
+						fail(k, lerr)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	err = <-listErr
+	if err == nil {
+		err = firstErr
+	}
+	return
+}
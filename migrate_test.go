@@ -0,0 +1,85 @@
+package riak
+
+import (
+	"bytes"
+	"http"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMigrateKey(t *testing.T) {
+	src := replayClient(t, Interaction{
+		RecordedRequest{Method: "GET", Path: "/riak/src/k"},
+		RecordedResponse{StatusCode: 200, Body: []byte("value"), Header: http.Header{"Content-Type": []string{"text/plain"}}},
+	})
+	// RestoreOverwrite looks for the current vclock first.
+	dst := replayClient(t,
+		Interaction{RecordedRequest{Method: "GET", Path: "/riak/dst/new-k"}, RecordedResponse{StatusCode: 404}},
+		Interaction{RecordedRequest{Method: "PUT", Path: "/riak/dst/new-k", Body: []byte("VALUE")}, RecordedResponse{StatusCode: 204}},
+		Interaction{
+			RecordedRequest{Method: "GET", Path: "/riak/dst/new-k"},
+			RecordedResponse{StatusCode: 200, Body: []byte("VALUE"), Header: http.Header{"Content-Type": []string{"text/plain"}}},
+		},
+	)
+	opts := MigrateOptions{
+		Policy:       RestoreOverwrite,
+		Verify:       true,
+		KeyTransform: func(k string) (string, bool) { return "new-" + k, true },
+		ValueTransform: func(k string, v []byte, hdr http.Header) ([]byte, os.Error) {
+			return bytes.ToUpper(v), nil
+		},
+	}
+	copied, err := MigrateKey(src, "src", dst, "dst", "k", opts)
+	fatalIf(t, err != nil, "MigrateKey failed: %v", err)
+	fatalIf(t, !copied, "Key wasn't copied")
+	allReplayed(t, dst)
+}
+
+func TestMigrateKeyVerifyFailed(t *testing.T) {
+	src := replayClient(t, Interaction{
+		RecordedRequest{Method: "GET", Path: "/riak/src/k"}, RecordedResponse{StatusCode: 200, Body: []byte("value")},
+	})
+	dst := replayClient(t,
+		Interaction{RecordedRequest{Method: "PUT", Path: "/riak/dst/k", Body: []byte("value")}, RecordedResponse{StatusCode: 204}},
+		Interaction{RecordedRequest{Method: "GET", Path: "/riak/dst/k"}, RecordedResponse{StatusCode: 200, Body: []byte("other")}},
+	)
+	_, err := MigrateKey(src, "src", dst, "dst", "k", MigrateOptions{Verify: true})
+	fatalIf(t, err != ErrVerifyFailed, "Expected ErrVerifyFailed, got %v", err)
+}
+
+// The destination's PUT only matches if n_val is sent along.
+func TestMigrateCopyProps(t *testing.T) {
+	src := replayClient(t,
+		Interaction{RecordedRequest{Method: "GET", Path: "/riak/src"}, RecordedResponse{StatusCode: 200, Body: []byte(`{"props":{"n_val":5}}`)}},
+		Interaction{
+			RecordedRequest{Method: "GET", Path: "/riak/src", Query: "keys=stream&props=false"},
+			RecordedResponse{StatusCode: 200, Body: []byte(`{"keys":[]}`)},
+		},
+	)
+	dst := replayClient(t, Interaction{
+		RecordedRequest{Method: "PUT", Path: "/riak/dst", Body: []byte(`{"props":{"n_val":5}}`)}, RecordedResponse{StatusCode: 204},
+	})
+	_, err := Migrate(src, "src", dst, "dst", MigrateOptions{CopyProps: true})
+	fatalIf(t, err != nil, "Migrate failed: %v", err)
+	allReplayed(t, dst)
+}
+
+func TestMigrateLog(t *testing.T) {
+	f, err := ioutil.TempFile("", "migrate")
+	fatalIf(t, err != nil, "Couldn't create log: %v", err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	ml, err := OpenMigrateLog(f.Name())
+	fatalIf(t, err != nil, "OpenMigrateLog failed: %v", err)
+	ml.record("a")
+	ml.record("b c")
+	ml.Close()
+
+	ml, err = OpenMigrateLog(f.Name())
+	fatalIf(t, err != nil, "OpenMigrateLog failed: %v", err)
+	defer ml.Close()
+	fatalIf(t, !ml.Done("a") || !ml.Done("b c"), "Log wasn't reloaded")
+	fatalIf(t, ml.Done("b"), "Unexpected key in log")
+}
@@ -0,0 +1,327 @@
+package riak
+
+import (
+	"fmt"
+	"http"
+	"io/ioutil"
+	"json"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Models are go structs saved and loaded directly through the riak HTTP interface.
+// Fields are mapped according to their `riak` struct tag:
+//
+//	riak:"key"              the riak key (string)
+//	riak:"vclock"           the vclock from the last load (string)
+//	riak:"index=NAME"       a secondary index header (string, []string, int, int64)
+//	riak:"link=TAG"         a link with riaktag TAG (Link or []Link)
+//	riak:"meta=NAME"        an X-Riak-Meta-NAME header (string)
+//	riak:"-"                not stored
+//
+// All other exported fields are stored as a JSON object in the body.
+
+// A Link refers to another item in riak.
+type Link struct {
+	Bucket string
+	Key    string
+}
+
+var ErrNotStruct = os.NewError("Model must be a pointer to a struct")
+var ErrNoKey = os.NewError("Model has no key")
+
+var linkType = reflect.TypeOf(Link{})
+var linkSliceType = reflect.TypeOf([]Link{})
+
+type modelField struct {
+	index int
+	kind  string // key, vclock, index, link, meta or body
+	arg   string
+}
+
+func modelFields(t reflect.Type) (fields []modelField, err os.Error) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := sf.Tag.Get("riak")
+		mf := modelField{index: i, kind: "body", arg: sf.Name}
+		switch {
+		case tag == "-":
+			continue
+		case tag == "":
+		case tag == "key" || tag == "vclock":
+			if sf.Type.Kind() != reflect.String {
+				return nil, os.NewError(fmt.Sprintf("riak: %s field %s must be a string", tag, sf.Name))
+			}
+			mf.kind = tag
+		case strings.HasPrefix(tag, "index="):
+			mf.kind, mf.arg = "index", tag[len("index="):]
+		case strings.HasPrefix(tag, "link="):
+			if sf.Type != linkType && sf.Type != linkSliceType {
+				return nil, os.NewError(fmt.Sprintf("riak: link field %s must be a Link or []Link", sf.Name))
+			}
+			mf.kind, mf.arg = "link", tag[len("link="):]
+		case strings.HasPrefix(tag, "meta="):
+			if sf.Type.Kind() != reflect.String {
+				return nil, os.NewError(fmt.Sprintf("riak: meta field %s must be a string", sf.Name))
+			}
+			mf.kind, mf.arg = "meta", tag[len("meta="):]
+		default:
+			return nil, os.NewError(fmt.Sprintf("riak: unknown tag on field %s: %s", sf.Name, tag))
+		}
+		fields = append(fields, mf)
+	}
+	return
+}
+
+func modelValue(v interface{}) (sv reflect.Value, err os.Error) {
+	pv := reflect.ValueOf(v)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() || pv.Elem().Kind() != reflect.Struct {
+		err = ErrNotStruct
+		return
+	}
+	sv = pv.Elem()
+	return
+}
+
+func indexValues(f reflect.Value) (out []string) {
+	switch f.Kind() {
+	case reflect.String:
+		if f.String() != "" {
+			out = []string{f.String()}
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		out = []string{strconv.Itoa64(f.Int())}
+	case reflect.Slice:
+		for i := 0; i < f.Len(); i++ {
+			out = append(out, indexValues(f.Index(i))...)
+		}
+	}
+	return
+}
+
+func setIndexValue(f reflect.Value, vals []string) (err os.Error) {
+	switch f.Kind() {
+	case reflect.String:
+		if len(vals) > 0 {
+			f.SetString(vals[0])
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if len(vals) > 0 {
+			var i int64
+			if i, err = strconv.Atoi64(vals[0]); err == nil {
+				f.SetInt(i)
+			}
+		}
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return os.NewError(fmt.Sprintf("riak: unsupported index type %s", f.Type()))
+		}
+		f.Set(reflect.ValueOf(vals))
+	default:
+		err = os.NewError(fmt.Sprintf("riak: unsupported index type %s", f.Type()))
+	}
+	return
+}
+
+func (self Client) linkHeader(l Link, tag string) string {
+	return fmt.Sprintf("<%s>; riaktag=\"%s\"", self.keyPath(l.Bucket, l.Key), tag)
+}
+
+// Parses a riak Link header into a map of riaktag -> links.  Links without a riaktag
+// (e.g., rel="up") are ignored.
+func parseLinks(hdr string) (out map[string][]Link) {
+	out = map[string][]Link{}
+	for _, part := range strings.Split(hdr, ",") {
+		part = strings.TrimSpace(part)
+		if !strings.HasPrefix(part, "<") {
+			continue
+		}
+		end := strings.Index(part, ">")
+		if end < 0 {
+			continue
+		}
+		target := strings.Split(strings.Trim(part[1:end], "/"), "/")
+		var tag string
+		for _, p := range strings.Split(part[end+1:], ";") {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "riaktag=") {
+				tag = strings.Trim(p[len("riaktag="):], "\"")
+			}
+		}
+		if tag == "" || len(target) < 2 {
+			continue
+		}
+		// the last two segments are bucket and key, regardless of prefix (except
+		// in /buckets/B/keys/K links).
+		l := Link{Bucket: target[len(target)-2], Key: target[len(target)-1]}
+		if n := len(target); n >= 4 && target[n-2] == "keys" && target[n-4] == "buckets" {
+			l.Bucket = target[n-3]
+		}
+		l.Bucket, l.Key = unescapeName(l.Bucket), unescapeName(l.Key)
+		out[tag] = append(out[tag], l)
+	}
+	return
+}
+
+func modelRequestParts(c Client, v interface{}) (key string, body []byte, hdrs http.Header, err os.Error) {
+	sv, err := modelValue(v)
+	if err != nil {
+		return
+	}
+	fields, err := modelFields(sv.Type())
+	if err != nil {
+		return
+	}
+	hdrs = http.Header{"Content-Type": []string{"application/json"}}
+	bmap := map[string]interface{}{}
+	for _, mf := range fields {
+		f := sv.Field(mf.index)
+		switch mf.kind {
+		case "key":
+			key = f.String()
+		case "vclock":
+			if f.String() != "" {
+				hdrs.Set("X-Riak-Vclock", f.String())
+			}
+		case "index":
+			for _, iv := range indexValues(f) {
+				hdrs.Add("X-Riak-Index-"+mf.arg, iv)
+			}
+		case "meta":
+			if f.String() != "" {
+				hdrs.Set("X-Riak-Meta-"+mf.arg, f.String())
+			}
+		case "link":
+			if l, ok := f.Interface().(Link); ok {
+				if l.Key != "" {
+					hdrs.Add("Link", c.linkHeader(l, mf.arg))
+				}
+			} else {
+				for _, l := range f.Interface().([]Link) {
+					hdrs.Add("Link", c.linkHeader(l, mf.arg))
+				}
+			}
+		case "body":
+			bmap[mf.arg] = f.Interface()
+		}
+	}
+	if key == "" {
+		err = ErrNoKey
+		return
+	}
+	body, err = json.Marshal(bmap)
+	return
+}
+
+// Stores the struct pointed to by v in bucket, under the value of its key field.
+// If the model has a vclock from a previous LoadModel or SaveModel, it is sent
+// with the write; either way, the vclock field is set to the one riak returns.
+func SaveModel(c Client, bucket string, v interface{}, parms http.Values, cc *http.ClientConn) (err os.Error) {
+	key, body, hdrs, err := modelRequestParts(c, v)
+	if err != nil {
+		return
+	}
+	respch := make(chan *http.Response)
+	vclock := make(chan string, 1)
+	go func() {
+		vc := ""
+		for resp := range respch {
+			vc = resp.Header.Get("X-Riak-Vclock")
+			resp.Body.Close()
+		}
+		vclock <- vc
+	}()
+	err = PutItemReturnBody(c, bucket, key, body, hdrs, parms, respch, cc)
+	if vc := <-vclock; err == nil && vc != "" {
+		err = setModelVclock(v, vc)
+	}
+	return
+}
+
+func setModelVclock(v interface{}, vclock string) (err os.Error) {
+	sv, err := modelValue(v)
+	if err != nil {
+		return
+	}
+	fields, err := modelFields(sv.Type())
+	for _, mf := range fields {
+		if mf.kind == "vclock" {
+			sv.Field(mf.index).SetString(vclock)
+		}
+	}
+	return
+}
+
+// Populates the struct pointed to by v from the item stored at bucket/key.
+func LoadModel(c Client, bucket, key string, v interface{}, parms http.Values, cc *http.ClientConn) (err os.Error) {
+	sv, err := modelValue(v)
+	if err != nil {
+		return
+	}
+	resp, err := GetItem(c, bucket, key, nil, parms, cc)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		err = loadModelResponse(sv, key, resp.Header, body)
+	}
+	return
+}
+
+func loadModelResponse(sv reflect.Value, key string, hdrs http.Header, body []byte) (err os.Error) {
+	fields, err := modelFields(sv.Type())
+	if err != nil {
+		return
+	}
+	bmap := map[string]json.RawMessage{}
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &bmap); err != nil {
+			return
+		}
+	}
+	links := parseLinks(strings.Join(hdrs["Link"], ","))
+	for _, mf := range fields {
+		f := sv.Field(mf.index)
+		switch mf.kind {
+		case "key":
+			f.SetString(key)
+		case "vclock":
+			f.SetString(hdrs.Get("X-Riak-Vclock"))
+		case "index":
+			vals := []string{}
+			for _, hv := range hdrs[http.CanonicalHeaderKey("X-Riak-Index-"+mf.arg)] {
+				for _, iv := range strings.Split(hv, ",") {
+					vals = append(vals, strings.TrimSpace(iv))
+				}
+			}
+			err = setIndexValue(f, vals)
+		case "meta":
+			f.SetString(hdrs.Get("X-Riak-Meta-" + mf.arg))
+		case "link":
+			if f.Type() == linkType {
+				l := Link{}
+				if ls := links[mf.arg]; len(ls) > 0 {
+					l = ls[0]
+				}
+				f.Set(reflect.ValueOf(l))
+			} else {
+				f.Set(reflect.ValueOf(links[mf.arg]))
+			}
+		case "body":
+			if raw, ok := bmap[mf.arg]; ok {
+				err = json.Unmarshal([]byte(raw), f.Addr().Interface())
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
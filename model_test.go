@@ -0,0 +1,121 @@
+package riak
+
+import (
+	"http"
+	"json"
+	"reflect"
+	"testing"
+)
+
+type testModel struct {
+	Id      string `riak:"key"`
+	Vclock  string `riak:"vclock"`
+	Email   string `riak:"index=email_bin"`
+	Age     int    `riak:"index=age_int"`
+	Friends []Link `riak:"link=friend"`
+	Owner   Link   `riak:"link=owner"`
+	Source  string `riak:"meta=source"`
+	Skip    string `riak:"-"`
+	Name    string
+	Tags    []string
+}
+
+func TestModelRequestParts(t *testing.T) {
+	c := testClient(t)
+	m := &testModel{
+		Id:      "TestModelRequestParts",
+		Vclock:  "a85hYGBgzGDKBVIcypz/fgaUHjmdwZTImMfKsPDOr3OZAAA=",
+		Email:   "test@example.com",
+		Age:     30,
+		Friends: []Link{{"people", "bob"}, {"people", "alice"}},
+		Source:  "tests",
+		Skip:    "skipped",
+		Name:    "Test",
+	}
+	key, body, hdrs, err := modelRequestParts(c, m)
+	fatalIf(t, err != nil, "Couldn't build model request: %v", err)
+	fatalIf(t, key != m.Id, "Wrong key: %s", key)
+	fatalIf(t, hdrs.Get("X-Riak-Vclock") != m.Vclock, "Wrong vclock: %s", hdrs.Get("X-Riak-Vclock"))
+	fatalIf(t, hdrs.Get("X-Riak-Index-email_bin") != m.Email, "Wrong email index: %s", hdrs.Get("X-Riak-Index-email_bin"))
+	fatalIf(t, hdrs.Get("X-Riak-Index-age_int") != "30", "Wrong age index: %s", hdrs.Get("X-Riak-Index-age_int"))
+	fatalIf(t, hdrs.Get("X-Riak-Meta-source") != "tests", "Wrong meta: %s", hdrs.Get("X-Riak-Meta-source"))
+	fatalIf(t, len(hdrs["Link"]) != 2, "Wrong number of links: %v", hdrs["Link"])
+	bmap := map[string]interface{}{}
+	err = json.Unmarshal(body, &bmap)
+	fatalIf(t, err != nil, "Couldn't decode body: %v", err)
+	fatalIf(t, bmap["Name"] != "Test", "Wrong name in body: %v", bmap["Name"])
+	_, found := bmap["Skip"]
+	fatalIf(t, found, "Skipped field stored in body")
+	_, found = bmap["Email"]
+	fatalIf(t, found, "Index field stored in body")
+}
+
+func TestModelNoKey(t *testing.T) {
+	c := testClient(t)
+	_, _, _, err := modelRequestParts(c, &testModel{})
+	fatalIf(t, err != ErrNoKey, "Expected ErrNoKey, got %v", err)
+	_, _, _, err = modelRequestParts(c, testModel{})
+	fatalIf(t, err != ErrNotStruct, "Expected ErrNotStruct, got %v", err)
+}
+
+// (structs can't be compared with ==)
+func sameLink(a, b Link) bool {
+	return a.Bucket == b.Bucket && a.Key == b.Key
+}
+
+func TestParseLinks(t *testing.T) {
+	links := parseLinks(`</riak/people>; rel="up", </riak/people/bob>; riaktag="friend", </riak/people/carol>; riaktag="owner"`)
+	fatalIf(t, len(links["friend"]) != 1, "Wrong friends: %v", links["friend"])
+	fatalIf(t, !sameLink(links["friend"][0], Link{"people", "bob"}), "Wrong friend: %v", links["friend"][0])
+	fatalIf(t, len(links["owner"]) != 1, "Wrong owner: %v", links["owner"])
+	fatalIf(t, len(links) != 2, "Unexpected links: %v", links)
+}
+
+func TestLoadModelResponse(t *testing.T) {
+	hdrs := http.Header{}
+	hdrs.Set("X-Riak-Vclock", "vclock")
+	hdrs.Set("X-Riak-Index-email_bin", "test@example.com")
+	hdrs.Set("X-Riak-Index-age_int", "30")
+	hdrs.Set("X-Riak-Meta-source", "tests")
+	hdrs.Set("Link", `</riak/people/bob>; riaktag="friend", </riak/people/carol>; riaktag="owner"`)
+	m := &testModel{}
+	err := loadModelResponse(reflect.ValueOf(m).Elem(), "TestLoadModelResponse", hdrs, []byte(`{"Name":"Test","Tags":["a","b"]}`))
+	fatalIf(t, err != nil, "Couldn't load model: %v", err)
+	fatalIf(t, m.Id != "TestLoadModelResponse", "Wrong key: %s", m.Id)
+	fatalIf(t, m.Vclock != "vclock", "Wrong vclock: %s", m.Vclock)
+	fatalIf(t, m.Email != "test@example.com", "Wrong email: %s", m.Email)
+	fatalIf(t, m.Age != 30, "Wrong age: %d", m.Age)
+	fatalIf(t, m.Source != "tests", "Wrong source: %s", m.Source)
+	fatalIf(t, len(m.Friends) != 1 || m.Friends[0].Key != "bob", "Wrong friends: %v", m.Friends)
+	fatalIf(t, m.Owner.Key != "carol", "Wrong owner: %v", m.Owner)
+	fatalIf(t, m.Name != "Test" || len(m.Tags) != 2, "Wrong body fields: %v", m)
+}
+
+type vclockModel struct {
+	Id     string `riak:"key"`
+	Vclock string `riak:"vclock"`
+	Name   string
+}
+
+func TestSaveModelKeepsVclock(t *testing.T) {
+	put := func(vclock string) Interaction {
+		return Interaction{
+			RecordedRequest{Method: "PUT", Path: "/riak/b/k", Query: "returnbody=true", Body: []byte(`{"Name":"x"}`)},
+			RecordedResponse{StatusCode: 200, Body: []byte(`{"Name":"x"}`), Header: http.Header{"X-Riak-Vclock": []string{vclock}}},
+		}
+	}
+	c := replayClient(t, put("v1"), put("v2"))
+	m := &vclockModel{Id: "k", Name: "x"}
+	err := SaveModel(c, "b", m, nil, nil)
+	fatalIf(t, err != nil || m.Vclock != "v1", "Vclock not kept: %q (%v)", m.Vclock, err)
+	err = SaveModel(c, "b", m, nil, nil)
+	fatalIf(t, err != nil || m.Vclock != "v2", "Vclock not updated: %q (%v)", m.Vclock, err)
+}
+
+func TestLoadModelBadIndex(t *testing.T) {
+	hdrs := http.Header{}
+	hdrs.Set("X-Riak-Index-age_int", "thirty")
+	m := &testModel{Age: 5}
+	err := loadModelResponse(reflect.ValueOf(m).Elem(), "k", hdrs, nil)
+	fatalIf(t, err == nil || m.Age != 5, "Bad integer index accepted: %d (%v)", m.Age, err)
+}
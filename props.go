@@ -5,17 +5,21 @@ import (
 	"os"
 )
 
+// A number of replicas, or QUORUM or ALL.  QuorumValue(0) is QUORUM; use
+// ZERO for a quorum of no replicas (e.g. pr=0).
 type QuorumValue int
 
 const (
 	QUORUM	QuorumValue =	-iota
 	ALL
+	ZERO
 )
 
 func (self QuorumValue)MarshalJSON()(out []byte, err os.Error){
 	switch self {
 		case QUORUM:	out, err = json.Marshal("quorum")
 		case ALL:			out, err = json.Marshal("all")
+		case ZERO:			out, err = json.Marshal(0)
 		default:			out, err = json.Marshal(int(self))
 	}
 	return
@@ -35,6 +39,7 @@ func (self *QuorumValue)UnmarshalJSON(in []byte)(err os.Error){
 		err = json.Unmarshal(in, &i)
 		if err == nil {
 			*self = QuorumValue(i)
+			if i == 0 { *self = ZERO }
 		}
 	}
 	return
@@ -74,6 +79,7 @@ func DefaultProperties()(Properties){
 
 func (self Properties)MarshalJSON()(out []byte, err os.Error){
 	omap := map[string]interface{}{}
+	if self.NVal != 0 { omap["n_val"] = self.NVal }
 	if self.Backend != "" { omap["backend"] = self.Backend }
 	if self.R != nil { omap["r"] = self.R }
 	if self.W != nil { omap["w"] = self.W }
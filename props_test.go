@@ -12,6 +12,12 @@ func TestQuorumStrings(t *testing.T){
 	out, err = json.Marshal(ALL)
 	fatalIf(t, err != nil, "Couldn't marshal QuorumValue: %v", err)
 	fatalIf(t, string(out) != `"all"`, "Got wrong value: '%s'", out)
+	out, err = json.Marshal(ZERO)
+	fatalIf(t, err != nil, "Couldn't marshal QuorumValue: %v", err)
+	fatalIf(t, string(out) != `0`, "Got wrong value: '%s'", out)
+	var q QuorumValue
+	err = json.Unmarshal([]byte(`0`), &q)
+	fatalIf(t, err != nil || q != ZERO, "0 unmarshalled as %v (%v)", q, err)
 	out, err = json.Marshal(QuorumValue(4))
 	fatalIf(t, err != nil, "Couldn't marshal QuorumValue: %v", err)
 	fatalIf(t, string(out) != `4`, "Got wrong value: '%s'", out)
@@ -0,0 +1,103 @@
+package main
+
+import "github.com/abneptis/riak"
+import (
+	"flag"
+	"fmt"
+	"http"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+var flag_url = flag.String("url", "http://localhost:8098/", "Riak root URL")
+var flag_restore = flag.Bool("restore", false, "Restore from the archive instead of writing one")
+var flag_file = flag.String("file", "-", "Archive to write (or read with -restore); - for stdout/stdin")
+var flag_gzip = flag.Bool("gzip", false, "Compress the archive (implied for files ending in .gz)")
+var flag_policy = flag.String("policy", "skip-existing", "With -restore, what to do with keys that already exist: skip-existing, overwrite or keep-vclock")
+var flag_all = flag.Bool("all", false, "Back up every bucket (ListBuckets)")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+	riak-backup [flags] BUCKET [...]     (or -all)
+	riak-backup -restore [flags]
+
+Flags:
+`)
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func logError(bucket, key string, err os.Error) {
+	log.Printf("%s - %s: %v", bucket, key, err)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	u, err := http.ParseURL(*flag_url)
+	if err != nil {
+		log.Fatalf("Bad -url: %v", err)
+	}
+	c, err := riak.NewClient("", *u)
+	if err != nil {
+		log.Fatalf("Couldn't create client: %v", err)
+	}
+	gz := *flag_gzip || strings.HasSuffix(*flag_file, ".gz")
+
+	var stats riak.BackupStats
+	if *flag_restore {
+		if flag.NArg() != 0 {
+			usage()
+		}
+		policy, perr := riak.ParseRestorePolicy(*flag_policy)
+		if perr != nil {
+			log.Fatal(perr)
+		}
+		var in io.Reader = os.Stdin
+		if *flag_file != "-" {
+			f, err := os.Open(*flag_file)
+			if err != nil {
+				log.Fatalf("Couldn't open archive: %v", err)
+			}
+			defer f.Close()
+			in = f
+		}
+		stats, err = riak.Restore(c, in, riak.RestoreOptions{Gzip: gz, Policy: policy, OnError: logError})
+		if err == nil {
+			log.Printf("Restored %d keys (%d values), skipped %d existing, %d errors",
+				stats.Keys, stats.Siblings, stats.Skipped, stats.Errors)
+		}
+	} else {
+		buckets := flag.Args()
+		if *flag_all {
+			if buckets, err = riak.ListBuckets(c, nil); err != nil {
+				log.Fatalf("Couldn't list buckets: %v", err)
+			}
+		}
+		if len(buckets) == 0 {
+			usage()
+		}
+		var out io.Writer = os.Stdout
+		if *flag_file != "-" {
+			f, err := os.Create(*flag_file)
+			if err != nil {
+				log.Fatalf("Couldn't create archive: %v", err)
+			}
+			defer f.Close()
+			out = f
+		}
+		stats, err = riak.Backup(c, buckets, out, riak.BackupOptions{Gzip: gz, OnError: logError})
+		if err == nil {
+			log.Printf("Backed up %d keys (%d values), %d deleted while running, %d errors",
+				stats.Keys, stats.Siblings, stats.Skipped, stats.Errors)
+		}
+	}
+	if err != nil {
+		log.Printf("riak-backup: %v", err)
+	}
+	if err != nil || stats.Errors > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import "github.com/abneptis/riak"
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"http"
+	"io"
+	"io/ioutil"
+	"json"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var flag_url = flag.String("url", "http://localhost:8098/", "Riak root URL")
+var flag_r = flag.String("r", "", "Read quorum (number, 'quorum' or 'all')")
+var flag_w = flag.String("w", "", "Write quorum")
+var flag_dw = flag.String("dw", "", "Durable-write quorum")
+var flag_rw = flag.String("rw", "", "Delete quorum")
+var flag_pr = flag.String("pr", "", "Primary read quorum for delete")
+var flag_pw = flag.String("pw", "", "Primary write quorum for delete")
+var flag_verify = flag.Bool("verify", false, "After delete, re-read the key and report whether its tombstone remains")
+var flag_verify_timeout = flag.Int("verify-timeout", 0, "With -verify, seconds to keep re-reading the key until its tombstone is reaped")
+var flag_format = flag.String("format", "raw", "Output format for get/siblings: raw, json or headers")
+var flag_file = flag.String("file", "", "Read the value for put (or the job for mapreduce) from this file")
+var flag_ctype = flag.String("content-type", "application/octet-stream", "Content-Type for put")
+var flag_vclock = flag.String("vclock", "", "Vclock to send with put/delete")
+var flag_discover = flag.Bool("discover", false, "Use the resource paths the server advertises (for non-default prefixes)")
+
+// Exit codes
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitNotFound
+	exitPreconditionFailed
+	exitUnavailable
+	exitBadRequest
+	exitUnacceptable
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: riak-cli [flags] COMMAND [ARGS]
+
+Commands:
+	ping
+	buckets
+	keys BUCKET
+	get BUCKET KEY
+	put BUCKET KEY [VALUE]        (value from -file or stdin if not given)
+	delete BUCKET KEY             (prints the vclock of any tombstone found)
+	props get BUCKET
+	props set BUCKET NAME=VALUE [...]
+	siblings BUCKET KEY
+	mapreduce                     (job from -file or stdin)
+	stats
+
+Flags:
+`)
+	flag.PrintDefaults()
+	os.Exit(exitUsage)
+}
+
+func exitCode(err os.Error) int {
+	switch err {
+	case nil:
+		return exitOK
+	case riak.ErrUnknownKey:
+		return exitNotFound
+	case riak.ErrPreconditionFailed:
+		return exitPreconditionFailed
+	case riak.ErrServiceUnavailable:
+		return exitUnavailable
+	case riak.ErrBadRequest:
+		return exitBadRequest
+	case riak.ErrUnacceptable:
+		return exitUnacceptable
+	}
+	return exitError
+}
+
+func quorumParms(names ...string) (parms http.Values) {
+	parms = http.Values{}
+	for _, n := range names {
+		if f := flag.Lookup(n); f != nil && f.Value.String() != "" {
+			parms.Set(n, f.Value.String())
+		}
+	}
+	return
+}
+
+// Reads the value from -file, or stdin
+func readInput() ([]byte, os.Error) {
+	if *flag_file != "" {
+		return ioutil.ReadFile(*flag_file)
+	}
+	return ioutil.ReadAll(os.Stdin)
+}
+
+func writeResponse(out io.Writer, resp *http.Response) (err os.Error) {
+	defer resp.Body.Close()
+	switch *flag_format {
+	case "raw":
+		_, err = io.Copy(out, resp.Body)
+	case "headers":
+		err = resp.Header.Write(out)
+	case "json":
+		var body []byte
+		body, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return
+		}
+		var value interface{} = string(body)
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+			var v interface{}
+			if json.Unmarshal(body, &v) == nil {
+				value = v
+			}
+		}
+		var ob []byte
+		ob, err = json.MarshalIndent(map[string]interface{}{
+			"headers": resp.Header,
+			"value":   value,
+		}, "", "  ")
+		if err == nil {
+			out.Write(ob)
+			fmt.Fprintln(out)
+		}
+	default:
+		err = os.NewError("Unknown -format: " + *flag_format)
+	}
+	return
+}
+
+func parseQuorum(s string) (q riak.QuorumValue, err os.Error) {
+	switch s {
+	case "quorum":
+		q = riak.QUORUM
+	case "all":
+		q = riak.ALL
+	case "0":
+		q = riak.ZERO
+	default:
+		var i int
+		i, err = strconv.Atoi(s)
+		q = riak.QuorumValue(i)
+	}
+	return
+}
+
+// nil for an unset flag
+func quorumOption(s string) (q *riak.QuorumValue, err os.Error) {
+	if s == "" {
+		return
+	}
+	var v riak.QuorumValue
+	if v, err = parseQuorum(s); err == nil {
+		q = &v
+	}
+	return
+}
+
+func deleteOptions() (opts riak.DeleteOptions, err os.Error) {
+	opts = riak.DeleteOptions{Vclock: *flag_vclock, Verify: *flag_verify, VerifyTimeout: int64(*flag_verify_timeout) * 1e9}
+	if opts.RW, err = quorumOption(*flag_rw); err != nil {
+		return
+	}
+	if opts.PR, err = quorumOption(*flag_pr); err != nil {
+		return
+	}
+	opts.PW, err = quorumOption(*flag_pw)
+	return
+}
+
+func parseProps(args []string) (props riak.Properties, err os.Error) {
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return props, os.NewError("Expected NAME=VALUE, got " + arg)
+		}
+		name, val := kv[0], kv[1]
+		switch name {
+		case "n_val":
+			props.NVal, err = strconv.Atoi(val)
+		case "allow_mult", "last_write_wins":
+			var b bool
+			b, err = strconv.Atob(val)
+			if name == "allow_mult" {
+				props.AllowMulti = &b
+			} else {
+				props.LastWriteWins = &b
+			}
+		case "r", "w", "dw", "rw":
+			var q riak.QuorumValue
+			q, err = parseQuorum(val)
+			switch name {
+			case "r":
+				props.R = &q
+			case "w":
+				props.W = &q
+			case "dw":
+				props.DW = &q
+			case "rw":
+				props.RW = &q
+			}
+		case "backend":
+			props.Backend = val
+		default:
+			err = os.NewError("Unknown property: " + name)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func run(c riak.Client, cmd string, args []string) (err os.Error) {
+	need := func(n int) {
+		if len(args) != n {
+			usage()
+		}
+	}
+	switch cmd {
+	case "ping":
+		need(0)
+		if err = riak.Ping(c, nil); err == nil {
+			fmt.Println("OK")
+		}
+	case "buckets":
+		need(0)
+		var names []string
+		if names, err = riak.ListBuckets(c, nil); err == nil {
+			for _, n := range names {
+				fmt.Println(n)
+			}
+		}
+	case "keys":
+		need(1)
+		ch := make(chan string, 64)
+		done := make(chan bool)
+		go func() {
+			for k := range ch {
+				fmt.Println(k)
+			}
+			done <- true
+		}()
+		err = riak.ListKeys(c, args[0], ch, nil)
+		<-done
+	case "get":
+		need(2)
+		var resp *http.Response
+		resp, err = riak.GetItem(c, args[0], args[1], nil, quorumParms("r"), nil)
+		if err == nil {
+			err = writeResponse(os.Stdout, resp)
+		}
+	case "put":
+		if len(args) != 2 && len(args) != 3 {
+			usage()
+		}
+		var value []byte
+		if len(args) == 3 {
+			value = []byte(args[2])
+		} else if value, err = readInput(); err != nil {
+			return
+		}
+		hdrs := http.Header{"Content-Type": []string{*flag_ctype}}
+		if *flag_vclock != "" {
+			hdrs.Set("X-Riak-Vclock", *flag_vclock)
+		}
+		err = riak.PutItem(c, args[0], args[1], value, hdrs, quorumParms("w", "dw"), nil)
+	case "delete":
+		need(2)
+		var opts riak.DeleteOptions
+		if opts, err = deleteOptions(); err != nil {
+			return
+		}
+		var res riak.DeleteResult
+		if res, err = riak.Delete(c, args[0], args[1], opts, nil); err != nil {
+			return
+		}
+		switch {
+		case res.Tombstone:
+			fmt.Println("tombstone", res.Vclock)
+		case res.Reaped:
+			fmt.Println("reaped")
+		}
+		if !res.Deleted {
+			err = riak.ErrUnknownKey
+		}
+	case "props":
+		if len(args) < 2 {
+			usage()
+		}
+		switch args[0] {
+		case "get":
+			need(2)
+			var bd riak.BucketDetails
+			if bd, err = riak.GetBucket(c, args[1], true, false, nil); err == nil {
+				var ob []byte
+				if ob, err = json.MarshalIndent(bd.Props, "", "  "); err == nil {
+					fmt.Println(string(ob))
+				}
+			}
+		case "set":
+			var props riak.Properties
+			if props, err = parseProps(args[2:]); err == nil {
+				err = riak.SetBucket(c, args[1], props, nil)
+			}
+		default:
+			usage()
+		}
+	case "siblings":
+		need(2)
+		respch := make(chan *http.Response)
+		done := make(chan os.Error)
+		go func() {
+			var werr os.Error
+			i := 0
+			for resp := range respch {
+				if *flag_format != "json" {
+					fmt.Printf("--- sibling %d\n", i)
+				}
+				if e := writeResponse(os.Stdout, resp); e != nil && werr == nil {
+					werr = e
+				}
+				if *flag_format == "raw" {
+					fmt.Println()
+				}
+				i++
+			}
+			done <- werr
+		}()
+		err = riak.GetMultiItem(c, args[0], args[1], nil, quorumParms("r"), respch, nil)
+		if werr := <-done; err == nil {
+			err = werr
+		}
+	case "mapreduce":
+		need(0)
+		var job []byte
+		if job, err = readInput(); err != nil {
+			return
+		}
+		var resp *http.Response
+		if resp, err = riak.MapReduce(c, bytes.TrimSpace(job), nil, nil); err == nil {
+			defer resp.Body.Close()
+			_, err = io.Copy(os.Stdout, resp.Body)
+		}
+	case "stats":
+		need(0)
+		var st riak.NodeStats
+		if st, err = riak.Stats(c, nil); err == nil {
+			var ob []byte
+			if ob, err = json.MarshalIndent(st, "", "  "); err == nil {
+				fmt.Println(string(ob))
+			}
+		}
+	default:
+		usage()
+	}
+	return
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+	u, err := http.ParseURL(*flag_url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "riak-cli: bad -url: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	c, err := riak.NewClient("", *u)
+	if err == nil && *flag_discover {
+		var res riak.Resources
+		if res, err = riak.Discover(c, nil); err == nil {
+			c = c.WithResources(res)
+		}
+	}
+	if err == nil {
+		err = run(c, flag.Arg(0), flag.Args()[1:])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "riak-cli: %v\n", err)
+	}
+	os.Exit(exitCode(err))
+}
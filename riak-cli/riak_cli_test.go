@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/abneptis/riak"
+	"testing"
+)
+
+func fatalIf(t *testing.T, test bool, s string, v ...interface{}) {
+	if test {
+		t.Fatalf(s, v...)
+	}
+}
+
+func TestParseQuorum(t *testing.T) {
+	for s, exp := range map[string]riak.QuorumValue{"quorum": riak.QUORUM, "all": riak.ALL, "3": 3, "0": riak.ZERO} {
+		q, err := parseQuorum(s)
+		fatalIf(t, err != nil || q != exp, "parseQuorum(%q) = %v (%v), expected %v", s, q, err, exp)
+	}
+	for _, bad := range []string{"", "one", "2x"} {
+		_, err := parseQuorum(bad)
+		fatalIf(t, err == nil, "parseQuorum(%q) accepted", bad)
+	}
+}
+
+func TestQuorumOption(t *testing.T) {
+	q, err := quorumOption("")
+	fatalIf(t, err != nil || q != nil, "Unset flag gave %v (%v)", q, err)
+	q, err = quorumOption("0")
+	fatalIf(t, err != nil || q == nil || *q != riak.ZERO, "-pr 0 gave %v (%v)", q, err)
+	_, err = quorumOption("most")
+	fatalIf(t, err == nil, "Bad quorum accepted")
+}
+
+func TestDeleteOptions(t *testing.T) {
+	defer func() { *flag_rw, *flag_pr, *flag_pw, *flag_vclock = "", "", "", "" }()
+	*flag_rw, *flag_pr, *flag_vclock = "all", "0", "abc"
+	opts, err := deleteOptions()
+	fatalIf(t, err != nil, "deleteOptions failed: %v", err)
+	fatalIf(t, opts.Vclock != "abc", "Wrong vclock: %s", opts.Vclock)
+	fatalIf(t, opts.RW == nil || *opts.RW != riak.ALL, "Wrong rw: %v", opts.RW)
+	fatalIf(t, opts.PR == nil || *opts.PR != riak.ZERO, "Wrong pr: %v", opts.PR)
+	fatalIf(t, opts.PW != nil || opts.R != nil, "Unset quorums set: %+v", opts)
+
+	*flag_pw = "some"
+	_, err = deleteOptions()
+	fatalIf(t, err == nil, "Bad -pw accepted")
+}
+
+func TestParseProps(t *testing.T) {
+	props, err := parseProps([]string{"n_val=5", "allow_mult=true", "last_write_wins=false", "r=quorum", "dw=0", "backend=leveldb"})
+	fatalIf(t, err != nil, "parseProps failed: %v", err)
+	fatalIf(t, props.NVal != 5 || props.Backend != "leveldb", "Wrong props: %+v", props)
+	fatalIf(t, props.AllowMulti == nil || !*props.AllowMulti, "Wrong allow_mult: %v", props.AllowMulti)
+	fatalIf(t, props.LastWriteWins == nil || *props.LastWriteWins, "Wrong last_write_wins: %v", props.LastWriteWins)
+	fatalIf(t, props.R == nil || *props.R != riak.QUORUM, "Wrong r: %v", props.R)
+	fatalIf(t, props.DW == nil || *props.DW != riak.ZERO, "Wrong dw: %v", props.DW)
+	fatalIf(t, props.W != nil || props.RW != nil, "Unset quorums set: %+v", props)
+	for _, bad := range []string{"n_val", "n_val=three", "allow_mult=maybe", "w=most", "colour=blue"} {
+		_, err = parseProps([]string{bad})
+		fatalIf(t, err == nil, "parseProps accepted %q", bad)
+	}
+}
+
+func TestQuorumParms(t *testing.T) {
+	defer func() { *flag_r, *flag_w = "", "" }()
+	*flag_w = "2"
+	parms := quorumParms("r", "w", "dw")
+	fatalIf(t, len(parms) != 1 || parms.Get("w") != "2", "Wrong parameters: %v", parms)
+}
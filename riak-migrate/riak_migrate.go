@@ -0,0 +1,92 @@
+package main
+
+import "github.com/abneptis/riak"
+import (
+	"flag"
+	"fmt"
+	"http"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var flag_src = flag.String("src", "http://localhost:8098/", "Source riak root URL")
+var flag_dst = flag.String("dst", "", "Destination riak root URL (defaults to -src)")
+var flag_threads = flag.Int("threads", riak.DefaultBatchWorkers, "Number of copy-threads")
+var flag_props = flag.Bool("copy-props", false, "Copy the bucket properties first")
+var flag_verify = flag.Bool("verify", false, "Re-read each key from the destination after writing it")
+var flag_policy = flag.String("policy", "skip-existing", "What to do with keys that already exist at the destination: skip-existing, overwrite or keep-vclock")
+var flag_log = flag.String("log", "", "Progress log; re-running with the same file resumes where a previous run stopped")
+var flag_strip = flag.String("strip-prefix", "", "Only copy keys with this prefix, and remove it from the destination key")
+var flag_add = flag.String("add-prefix", "", "Prefix to add to each destination key")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: riak-migrate [flags] SRCBUCKET DSTBUCKET\n\nFlags:\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func client(rawurl string) riak.Client {
+	u, err := http.ParseURL(rawurl)
+	if err != nil {
+		log.Fatalf("Bad url %q: %v", rawurl, err)
+	}
+	c, err := riak.NewClient("", *u)
+	if err != nil {
+		log.Fatalf("Couldn't create client: %v", err)
+	}
+	return c
+}
+
+func transformKey(k string) (string, bool) {
+	if !strings.HasPrefix(k, *flag_strip) {
+		return "", false
+	}
+	return *flag_add + k[len(*flag_strip):], true
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 || *flag_threads < 1 {
+		usage()
+	}
+	if *flag_dst == "" {
+		*flag_dst = *flag_src
+	}
+	src, dst := client(*flag_src), client(*flag_dst)
+	policy, err := riak.ParseRestorePolicy(*flag_policy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := riak.MigrateOptions{
+		Workers:   *flag_threads,
+		CopyProps: *flag_props,
+		Policy:    policy,
+		Verify:    *flag_verify,
+		OnError: func(key string, err os.Error) {
+			log.Printf("%s: %v", key, err)
+		},
+	}
+	if *flag_strip != "" || *flag_add != "" {
+		opts.KeyTransform = transformKey
+	}
+	if *flag_log != "" {
+		if opts.Log, err = riak.OpenMigrateLog(*flag_log); err != nil {
+			log.Fatalf("Couldn't open log: %v", err)
+		}
+		defer opts.Log.Close()
+	}
+
+	start := time.Seconds()
+	stats, err := riak.Migrate(src, flag.Arg(0), dst, flag.Arg(1), opts)
+	log.Printf("finished in %ds: listed %d, copied %d, verified %d, skipped %d, errors %d",
+		time.Seconds()-start, stats.Listed, stats.Copied, stats.Verified, stats.Skipped, stats.Failed)
+	if err != nil {
+		log.Printf("riak-migrate: %v", err)
+	}
+	if err != nil || stats.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import "github.com/abneptis/riak"
+import (
+	"flag"
+	"fmt"
+	"http"
+	"io"
+	"json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var flag_nodes = flag.String("nodes", "http://localhost:8098/", "Comma-separated root URLs of the nodes to poll")
+var flag_interval = flag.Int("interval", 10, "Seconds between polls")
+var flag_timeout = flag.Int("timeout", 5, "Seconds before a poll is abandoned (and the node marked down)")
+var flag_listen = flag.String("listen", ":9098", "Address to serve /status (JSON) and /metrics (Prometheus) on")
+var flag_get99 = flag.Int64("alert-get-p99", 0, "Alert when a node's 99th percentile get time exceeds this many microseconds (0 to disable)")
+var flag_put99 = flag.Int64("alert-put-p99", 0, "Alert when a node's 99th percentile put time exceeds this many microseconds (0 to disable)")
+var flag_get95 = flag.Int64("alert-get-p95", 0, "As -alert-get-p99, for the 95th percentile")
+var flag_put95 = flag.Int64("alert-put-p95", 0, "As -alert-put-p99, for the 95th percentile")
+
+type nodeStatus struct {
+	URL string
+	Up  bool
+	// Round trip time of the last ping, in microseconds
+	PingLatency int64
+	LastError   string
+	// Unix times
+	LastPoll   int64
+	LastChange int64
+	Stats      *riak.NodeStats
+	Alerts     []string
+}
+
+type clusterStatus struct {
+	Nodes []*nodeStatus
+	// True if the up nodes don't agree on the ring members
+	RingDisagreement bool
+	// Distinct membership lists, by node URL
+	RingMembers map[string][]string
+	Alerts      []string
+}
+
+type monitor struct {
+	lock    sync.Mutex
+	clients []riak.Client
+	status  clusterStatus
+}
+
+func newMonitor(urls []string) (m *monitor, err os.Error) {
+	m = &monitor{}
+	for _, raw := range urls {
+		u, perr := http.ParseURL(strings.TrimSpace(raw))
+		if perr != nil {
+			return nil, perr
+		}
+		c, cerr := riak.NewClient("riak-monitor", *u)
+		if cerr != nil {
+			return nil, cerr
+		}
+		m.clients = append(m.clients, c)
+		m.status.Nodes = append(m.status.Nodes, &nodeStatus{URL: u.String()})
+	}
+	return
+}
+
+type threshold struct {
+	name  string
+	limit int64
+	value func(*riak.NodeStats) int64
+}
+
+var thresholds = []threshold{
+	{"get_p95", 0, func(s *riak.NodeStats) int64 { return s.GetFSMTime.P95 }},
+	{"get_p99", 0, func(s *riak.NodeStats) int64 { return s.GetFSMTime.P99 }},
+	{"put_p95", 0, func(s *riak.NodeStats) int64 { return s.PutFSMTime.P95 }},
+	{"put_p99", 0, func(s *riak.NodeStats) int64 { return s.PutFSMTime.P99 }},
+}
+
+func nodeAlerts(s *riak.NodeStats) (alerts []string) {
+	for _, t := range thresholds {
+		if t.limit > 0 && t.value(s) > t.limit {
+			alerts = append(alerts, fmt.Sprintf("%s over %dus", t.name, t.limit))
+		}
+	}
+	return
+}
+
+// Polls a single node; the ping and stats requests share the timeout.
+func poll(c riak.Client) (ns nodeStatus) {
+	ctx := riak.NewTimeoutContext(int64(*flag_timeout) * 1e9)
+	defer ctx.Stop()
+	c = c.WithContext(ctx)
+	ns.LastPoll = time.Seconds()
+	start := time.Nanoseconds()
+	err := riak.Ping(c, nil)
+	ns.PingLatency = (time.Nanoseconds() - start) / 1e3
+	if err == nil {
+		var st riak.NodeStats
+		if st, err = riak.Stats(c, nil); err == nil {
+			ns.Stats = &st
+			ns.Alerts = nodeAlerts(&st)
+		} else if err == riak.ErrStatsDisabled {
+			err = nil
+		}
+	}
+	ns.Up = err == nil
+	if err != nil {
+		ns.LastError = err.String()
+	}
+	return
+}
+
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *monitor) pollAll() {
+	results := make([]nodeStatus, len(self.clients))
+	wg := &sync.WaitGroup{}
+	for i, c := range self.clients {
+		wg.Add(1)
+		go func(i int, c riak.Client) {
+			defer wg.Done()
+			results[i] = poll(c)
+		}(i, c)
+	}
+	wg.Wait()
+	self.update(results)
+}
+
+// Merges the results of a poll (one per node, in order) into the status.
+func (self *monitor) update(results []nodeStatus) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	st := &self.status
+	st.RingMembers = map[string][]string{}
+	st.RingDisagreement = false
+	previous := map[string]bool{}
+	for _, a := range st.Alerts {
+		previous[a] = true
+	}
+	st.Alerts = nil
+	var first []string
+	for i, r := range results {
+		old := st.Nodes[i]
+		r.URL = old.URL
+		r.LastChange = old.LastChange
+		if r.Up != old.Up || old.LastPoll == 0 {
+			r.LastChange = r.LastPoll
+			if r.Up {
+				log.Printf("%s: up", r.URL)
+			} else {
+				log.Printf("%s: down (%s)", r.URL, r.LastError)
+			}
+		}
+		if !r.Up {
+			st.Alerts = append(st.Alerts, r.URL+": down")
+		}
+		for _, a := range r.Alerts {
+			st.Alerts = append(st.Alerts, r.URL+": "+a)
+		}
+		if r.Stats != nil && len(r.Stats.RingMembers) > 0 {
+			members := append([]string{}, r.Stats.RingMembers...)
+			sort.SortStrings(members)
+			st.RingMembers[r.URL] = members
+			if first == nil {
+				first = members
+			} else if !sameMembers(first, members) {
+				st.RingDisagreement = true
+			}
+		}
+		*old = r
+	}
+	if st.RingDisagreement {
+		st.Alerts = append(st.Alerts, "ring membership disagreement")
+	}
+	// only new alerts are logged
+	for _, a := range st.Alerts {
+		if !previous[a] {
+			log.Printf("ALERT %s", a)
+		}
+	}
+}
+
+func (self *monitor) serveStatus(w http.ResponseWriter, r *http.Request) {
+	self.lock.Lock()
+	out, err := json.MarshalIndent(self.status, "", "  ")
+	self.lock.Unlock()
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (self *monitor) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	self.writeMetrics(w)
+}
+
+// See http://prometheus.io/docs/instrumenting/exposition_formats/
+func (self *monitor) writeMetrics(w io.Writer) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	gauge := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	gauge("riak_up", "Whether the node answered the last ping")
+	for _, n := range self.status.Nodes {
+		fmt.Fprintf(w, "riak_up{node=%q} %d\n", n.URL, boolMetric(n.Up))
+	}
+	gauge("riak_ping_latency_microseconds", "Round trip time of the last ping")
+	for _, n := range self.status.Nodes {
+		fmt.Fprintf(w, "riak_ping_latency_microseconds{node=%q} %d\n", n.URL, n.PingLatency)
+	}
+	type fsmTime struct {
+		node, op string
+		p        riak.Percentiles
+	}
+	var fsmTimes []fsmTime
+	for _, n := range self.status.Nodes {
+		if n.Stats != nil {
+			fsmTimes = append(fsmTimes, fsmTime{n.URL, "get", n.Stats.GetFSMTime}, fsmTime{n.URL, "put", n.Stats.PutFSMTime})
+		}
+	}
+	gauge("riak_fsm_time_microseconds", "Get/put FSM times over the last minute, by quantile")
+	for _, f := range fsmTimes {
+		for _, q := range []struct {
+			quantile string
+			value    int64
+		}{{"0.5", f.p.Median}, {"0.95", f.p.P95}, {"0.99", f.p.P99}, {"1", f.p.P100}} {
+			fmt.Fprintf(w, "riak_fsm_time_microseconds{node=%q,op=%q,quantile=%q} %d\n", f.node, f.op, q.quantile, q.value)
+		}
+	}
+	gauge("riak_fsm_time_mean_microseconds", "Mean get/put FSM time over the last minute")
+	for _, f := range fsmTimes {
+		fmt.Fprintf(w, "riak_fsm_time_mean_microseconds{node=%q,op=%q} %d\n", f.node, f.op, f.p.Mean)
+	}
+	gauge("riak_node_ops", "Node gets/puts over the last minute")
+	for _, n := range self.status.Nodes {
+		if n.Stats != nil {
+			fmt.Fprintf(w, "riak_node_ops{node=%q,op=\"get\"} %d\n", n.URL, n.Stats.NodeGets)
+			fmt.Fprintf(w, "riak_node_ops{node=%q,op=\"put\"} %d\n", n.URL, n.Stats.NodePuts)
+		}
+	}
+	gauge("riak_ring_members", "Number of ring members the node reports")
+	for _, n := range self.status.Nodes {
+		if n.Stats != nil {
+			fmt.Fprintf(w, "riak_ring_members{node=%q} %d\n", n.URL, len(n.Stats.RingMembers))
+		}
+	}
+	gauge("riak_ring_disagreement", "Whether the up nodes disagree on the ring members")
+	fmt.Fprintf(w, "riak_ring_disagreement %d\n", boolMetric(self.status.RingDisagreement))
+	gauge("riak_alerts", "Number of active alerts")
+	fmt.Fprintf(w, "riak_alerts %d\n", len(self.status.Alerts))
+}
+
+func main() {
+	flag.Parse()
+	if *flag_interval < 1 || *flag_timeout < 1 {
+		log.Fatalf("-interval and -timeout must be positive")
+	}
+	thresholds[0].limit = *flag_get95
+	thresholds[1].limit = *flag_get99
+	thresholds[2].limit = *flag_put95
+	thresholds[3].limit = *flag_put99
+
+	m, err := newMonitor(strings.Split(*flag_nodes, ","))
+	if err != nil {
+		log.Fatalf("Bad -nodes: %v", err)
+	}
+	m.pollAll()
+	go func() {
+		t := time.NewTicker(int64(*flag_interval) * 1e9)
+		for _ = range t.C {
+			m.pollAll()
+		}
+	}()
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) { m.serveStatus(w, r) })
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) { m.serveMetrics(w, r) })
+	log.Printf("Serving /status and /metrics on %s", *flag_listen)
+	log.Fatal(http.ListenAndServe(*flag_listen, nil))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"github.com/abneptis/riak"
+	"github.com/abneptis/riak/riaktest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fatalIf(t *testing.T, test bool, s string, v ...interface{}) {
+	if test {
+		t.Fatalf(s, v...)
+	}
+}
+
+func testMonitor(t *testing.T) *monitor {
+	m, err := newMonitor([]string{"http://a:8098/", "http://b:8098/", "http://c:8098/"})
+	fatalIf(t, err != nil, "Couldn't create monitor: %v", err)
+	return m
+}
+
+func upNode(members ...string) nodeStatus {
+	return nodeStatus{Up: true, LastPoll: 1, Stats: &riak.NodeStats{RingMembers: members}}
+}
+
+func TestRingDisagreement(t *testing.T) {
+	m := testMonitor(t)
+	// order doesn't matter, and down nodes don't count
+	m.update([]nodeStatus{upNode("x", "y"), upNode("y", "x"), {LastPoll: 1, LastError: "timeout"}})
+	fatalIf(t, m.status.RingDisagreement, "Same members reported as a disagreement")
+	fatalIf(t, len(m.status.Alerts) != 1 || m.status.Alerts[0] != "http://c:8098/: down", "Wrong alerts: %v", m.status.Alerts)
+
+	m.update([]nodeStatus{upNode("x", "y"), upNode("x"), upNode("x", "y")})
+	fatalIf(t, !m.status.RingDisagreement, "Disagreement not detected")
+	fatalIf(t, len(m.status.RingMembers["http://b:8098/"]) != 1, "Wrong members: %v", m.status.RingMembers)
+	fatalIf(t, m.status.Nodes[2].LastChange != 1 || !m.status.Nodes[2].Up, "Node c not marked up: %+v", m.status.Nodes[2])
+}
+
+func TestAlertThresholds(t *testing.T) {
+	defer func() {
+		for i := range thresholds {
+			thresholds[i].limit = 0
+		}
+	}()
+	s := &riak.NodeStats{}
+	s.GetFSMTime.P95 = 2000
+	s.GetFSMTime.P99 = 5000
+	s.PutFSMTime.P99 = 5000
+	fatalIf(t, len(nodeAlerts(s)) != 0, "Alerts with no thresholds: %v", nodeAlerts(s))
+	thresholds[0].limit = 2000 // get_p95: not over
+	thresholds[1].limit = 4000 // get_p99
+	thresholds[3].limit = 4000 // put_p99
+	alerts := nodeAlerts(s)
+	fatalIf(t, len(alerts) != 2 || alerts[0] != "get_p99 over 4000us" || alerts[1] != "put_p99 over 4000us", "Wrong alerts: %v", alerts)
+}
+
+func TestMetrics(t *testing.T) {
+	m := testMonitor(t)
+	n := upNode("x")
+	n.Stats.GetFSMTime = riak.Percentiles{Mean: 1, Median: 2, P95: 3, P99: 4, P100: 5}
+	m.update([]nodeStatus{n, upNode("x", "y"), {LastPoll: 1}})
+	out := bytes.NewBuffer(nil)
+	m.writeMetrics(out)
+	for _, line := range []string{
+		`riak_up{node="http://a:8098/"} 1`,
+		`riak_up{node="http://c:8098/"} 0`,
+		`riak_fsm_time_microseconds{node="http://a:8098/",op="get",quantile="0.5"} 2`,
+		`riak_fsm_time_microseconds{node="http://a:8098/",op="get",quantile="0.95"} 3`,
+		`riak_fsm_time_microseconds{node="http://a:8098/",op="get",quantile="1"} 5`,
+		`riak_fsm_time_mean_microseconds{node="http://a:8098/",op="get"} 1`,
+		`riak_ring_disagreement 1`,
+		`riak_alerts 2`,
+	} {
+		fatalIf(t, !strings.Contains(out.String(), line+"\n"), "Missing %s in:\n%s", line, out.String())
+	}
+	fatalIf(t, strings.Contains(out.String(), `quantile="mean"`), "Non-numeric quantile in:\n%s", out.String())
+}
+
+// Each poll must let go of its connections (and context watchers) once done,
+// or a long-running monitor leaks a few per node per interval.
+func TestPollsDontLeak(t *testing.T) {
+	s := riaktest.NewServer()
+	defer s.Close()
+	m, err := newMonitor([]string{s.URL + "/", s.URL + "/"})
+	fatalIf(t, err != nil, "Couldn't create monitor: %v", err)
+	m.pollAll()
+	time.Sleep(1e8)
+	before := runtime.Goroutines()
+	for i := 0; i < 10; i++ {
+		m.pollAll()
+	}
+	fatalIf(t, !m.status.Nodes[0].Up, "Node down: %+v", m.status.Nodes[0])
+	time.Sleep(1e8)
+	fatalIf(t, runtime.Goroutines() > before+2, "%d goroutines before the polls, %d after", before, runtime.Goroutines())
+}
@@ -0,0 +1,372 @@
+package main
+
+import "github.com/abneptis/riak"
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"http"
+	"io"
+	"io/ioutil"
+	"json"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var flag_url = flag.String("url", "http://localhost:8098/", "Riak root URL")
+var flag_page = flag.Int("page", 20, "Keys per page for ls (0 for no paging)")
+var flag_history = flag.String("history", path.Join(os.Getenv("HOME"), ".riak_shell_history"), "History file ('' to disable)")
+
+const help = `Commands:
+	use BUCKET          select the bucket for the other commands
+	ls                  list the keys (paged; enter for more, q to stop)
+	get KEY             show the value (JSON is pretty-printed)
+	put KEY VALUE       store VALUE (the rest of the line) (application/json if it parses, text/plain otherwise)
+	put KEY             as above, reading the value up to a line containing only '.'
+	rm KEY              delete the key
+	props               show the bucket properties
+	siblings KEY        show every sibling of the key
+	buckets             list the buckets
+	history             show the command history
+	!N                  re-run history entry N
+	help
+	quit
+`
+
+type shell struct {
+	c       riak.Client
+	bucket  string
+	in      *bufio.Reader
+	out     io.Writer
+	history []string
+	hfile   *os.File
+}
+
+var shellCommands = map[string]func(*shell, []string) os.Error{
+	"use":      (*shell).use,
+	"ls":       (*shell).ls,
+	"get":      (*shell).get,
+	"put":      (*shell).put,
+	"rm":       (*shell).rm,
+	"props":    (*shell).props,
+	"siblings": (*shell).siblings,
+	"buckets":  (*shell).buckets,
+	"history":  (*shell).showHistory,
+	"help": func(self *shell, args []string) os.Error {
+		fmt.Fprint(self.out, help)
+		return nil
+	},
+}
+
+var errUsage = os.NewError("Wrong number of arguments (try 'help')")
+var errNoBucket = os.NewError("No bucket selected (try 'use BUCKET')")
+
+func (self *shell) readLine() (line string, err os.Error) {
+	line, err = self.in.ReadString('\n')
+	if err == os.EOF && line != "" {
+		err = nil
+	}
+	line = strings.TrimRight(line, "\r\n")
+	return
+}
+
+func (self *shell) needBucket(args []string, n int) os.Error {
+	if self.bucket == "" {
+		return errNoBucket
+	}
+	if len(args) != n {
+		return errUsage
+	}
+	return nil
+}
+
+func (self *shell) use(args []string) os.Error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	self.bucket = args[0]
+	return nil
+}
+
+// Keys are streamed; when the user stops paging, the listing is canceled.
+func (self *shell) ls(args []string) (err os.Error) {
+	if err = self.needBucket(args, 0); err != nil {
+		return
+	}
+	ctx := riak.NewContext()
+	keys := make(chan string, 64)
+	listErr := make(chan os.Error, 1)
+	go func() { listErr <- riak.ListKeys(self.c.WithContext(ctx), self.bucket, keys, nil) }()
+	n := 0
+	for k := range keys {
+		fmt.Fprintln(self.out, k)
+		n++
+		if *flag_page > 0 && n%*flag_page == 0 {
+			fmt.Fprint(self.out, "-- more --")
+			if line, rerr := self.readLine(); rerr != nil || strings.TrimSpace(line) == "q" {
+				ctx.Cancel()
+				for _ = range keys {
+				}
+				break
+			}
+		}
+	}
+	if err = <-listErr; err == riak.ErrCanceled {
+		err = nil
+	}
+	fmt.Fprintf(self.out, "(%d keys)\n", n)
+	return
+}
+
+func prettyJSON(body []byte) ([]byte, bool) {
+	var v interface{}
+	if json.Unmarshal(body, &v) != nil {
+		return body, false
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return body, false
+	}
+	return out, true
+}
+
+func (self *shell) printResponse(resp *http.Response) (err os.Error) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	ctype := resp.Header.Get("Content-Type")
+	if strings.HasPrefix(ctype, "application/json") {
+		body, _ = prettyJSON(body)
+	}
+	fmt.Fprintf(self.out, "Content-Type: %s\nX-Riak-Vclock: %s\n\n", ctype, resp.Header.Get("X-Riak-Vclock"))
+	self.out.Write(body)
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		fmt.Fprintln(self.out)
+	}
+	return
+}
+
+func (self *shell) get(args []string) (err os.Error) {
+	if err = self.needBucket(args, 1); err != nil {
+		return
+	}
+	resp, err := riak.GetItem(self.c, self.bucket, args[0], nil, nil, nil)
+	if err == nil {
+		err = self.printResponse(resp)
+	}
+	return
+}
+
+func (self *shell) put(args []string) (err os.Error) {
+	if self.bucket == "" {
+		return errNoBucket
+	}
+	if len(args) < 1 {
+		return errUsage
+	}
+	var value string
+	if len(args) > 2 {
+		return errUsage
+	}
+	if len(args) == 2 {
+		value = args[1]
+	} else {
+		var lines []string
+		for {
+			line, rerr := self.readLine()
+			if rerr != nil || line == "." {
+				break
+			}
+			lines = append(lines, line)
+		}
+		value = strings.Join(lines, "\n")
+	}
+	ctype := "text/plain"
+	if _, ok := prettyJSON([]byte(value)); ok {
+		ctype = "application/json"
+	}
+	// write with the current vclock, so the new value replaces the old one
+	// (and any siblings) instead of becoming another sibling.
+	hdrs := http.Header{"Content-Type": []string{ctype}}
+	rec, err := riak.FetchRecord(self.c, self.bucket, args[0])
+	switch err {
+	case nil:
+		hdrs.Set("X-Riak-Vclock", rec.Vclock)
+	case riak.ErrUnknownKey:
+	default:
+		return
+	}
+	return riak.PutItem(self.c, self.bucket, args[0], []byte(value), hdrs, nil, nil)
+}
+
+func (self *shell) rm(args []string) (err os.Error) {
+	if err = self.needBucket(args, 1); err != nil {
+		return
+	}
+	return riak.DeleteItem(self.c, self.bucket, args[0], nil, nil)
+}
+
+func (self *shell) props(args []string) (err os.Error) {
+	if err = self.needBucket(args, 0); err != nil {
+		return
+	}
+	bd, err := riak.GetBucket(self.c, self.bucket, true, false, nil)
+	if err != nil {
+		return
+	}
+	out, err := json.MarshalIndent(bd.Props, "", "  ")
+	if err == nil {
+		fmt.Fprintln(self.out, string(out))
+	}
+	return
+}
+
+func (self *shell) siblings(args []string) (err os.Error) {
+	if err = self.needBucket(args, 1); err != nil {
+		return
+	}
+	respch := make(chan *http.Response)
+	done := make(chan os.Error)
+	go func() {
+		var perr os.Error
+		i := 0
+		for resp := range respch {
+			fmt.Fprintf(self.out, "--- sibling %d\n", i)
+			if e := self.printResponse(resp); e != nil && perr == nil {
+				perr = e
+			}
+			i++
+		}
+		done <- perr
+	}()
+	hdrs := http.Header{"Accept": []string{"multipart/mixed, */*;q=0.5"}}
+	err = riak.GetMultiItem(self.c, self.bucket, args[0], hdrs, nil, respch, nil)
+	if perr := <-done; err == nil {
+		err = perr
+	}
+	return
+}
+
+func (self *shell) buckets(args []string) (err os.Error) {
+	if len(args) != 0 {
+		return errUsage
+	}
+	names, err := riak.ListBuckets(self.c, nil)
+	for _, n := range names {
+		fmt.Fprintln(self.out, n)
+	}
+	return
+}
+
+func (self *shell) showHistory(args []string) os.Error {
+	for i, h := range self.history {
+		fmt.Fprintf(self.out, "%4d  %s\n", i+1, h)
+	}
+	return nil
+}
+
+func (self *shell) loadHistory(name string) {
+	if name == "" {
+		return
+	}
+	if f, err := os.Open(name); err == nil {
+		r := bufio.NewReader(f)
+		for line, rerr := r.ReadString('\n'); rerr == nil; line, rerr = r.ReadString('\n') {
+			self.history = append(self.history, strings.TrimRight(line, "\n"))
+		}
+		f.Close()
+	}
+	self.hfile, _ = os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
+
+func (self *shell) addHistory(line string) {
+	self.history = append(self.history, line)
+	if self.hfile != nil {
+		self.hfile.WriteString(line + "\n")
+	}
+}
+
+// Returns the first n whitespace-separated fields of line, and the rest of the
+// line as it was (less the whitespace separating it from the fields).
+func leadingFields(line string, n int) (fields []string, rest string) {
+	rest = strings.TrimLeftFunc(line, unicode.IsSpace)
+	for i := 0; i < n && rest != ""; i++ {
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		fields = append(fields, rest[:end])
+		rest = strings.TrimLeftFunc(rest[end:], unicode.IsSpace)
+	}
+	return
+}
+
+func (self *shell) run(line string) (err os.Error) {
+	if strings.HasPrefix(line, "!") {
+		n, perr := strconv.Atoi(line[1:])
+		if perr != nil || n < 1 || n > len(self.history) {
+			return os.NewError("No such history entry: " + line)
+		}
+		line = self.history[n-1]
+		fmt.Fprintln(self.out, line)
+	}
+	self.addHistory(line)
+	fields := strings.Fields(line)
+	if fields[0] == "put" && len(fields) > 2 {
+		// the value is the rest of the line, whitespace and all
+		var value string
+		fields, value = leadingFields(line, 2)
+		fields = append(fields, value)
+	}
+	cmd, ok := shellCommands[fields[0]]
+	if !ok {
+		return os.NewError("Unknown command: " + fields[0] + " (try 'help')")
+	}
+	return cmd(self, fields[1:])
+}
+
+func (self *shell) prompt() {
+	fmt.Fprintf(self.out, "riak:%s> ", self.bucket)
+}
+
+func main() {
+	flag.Parse()
+	u, err := http.ParseURL(*flag_url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "riak-shell: bad -url: %v\n", err)
+		os.Exit(2)
+	}
+	c, err := riak.NewClient("", *u)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "riak-shell: %v\n", err)
+		os.Exit(1)
+	}
+	sh := &shell{c: c, in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	sh.loadHistory(*flag_history)
+	if flag.NArg() > 0 {
+		sh.bucket = flag.Arg(0)
+	}
+	for {
+		sh.prompt()
+		line, err := sh.readLine()
+		if err != nil {
+			fmt.Fprintln(sh.out)
+			break
+		}
+		line = strings.TrimSpace(line)
+		switch line {
+		case "":
+			continue
+		case "quit", "exit":
+			return
+		}
+		if err = sh.run(line); err != nil {
+			fmt.Fprintf(sh.out, "error: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/abneptis/riak"
+	"github.com/abneptis/riak/riaktest"
+	"http"
+	"strings"
+	"testing"
+)
+
+func fatalIf(t *testing.T, test bool, s string, v ...interface{}) {
+	if test {
+		t.Fatalf(s, v...)
+	}
+}
+
+func testShell(t *testing.T, s *riaktest.Server, input string) (sh *shell, out *bytes.Buffer) {
+	out = bytes.NewBuffer(nil)
+	sh = &shell{in: bufio.NewReader(strings.NewReader(input)), out: out}
+	if s != nil {
+		u, err := http.ParseURL(s.URL)
+		fatalIf(t, err != nil, "Couldn't parse server url: %v", err)
+		sh.c, err = riak.NewClient("riak-shell", *u)
+		fatalIf(t, err != nil, "Couldn't create client: %v", err)
+	}
+	return
+}
+
+func TestCommandParsing(t *testing.T) {
+	sh, out := testShell(t, nil, "")
+	err := sh.run("frobnicate x")
+	fatalIf(t, err == nil || !strings.Contains(err.String(), "Unknown command"), "Unknown command accepted: %v", err)
+	err = sh.run("get key")
+	fatalIf(t, err != errNoBucket, "Expected errNoBucket, got %v", err)
+	err = sh.run("use")
+	fatalIf(t, err != errUsage, "Expected errUsage, got %v", err)
+	err = sh.run("use  people ")
+	fatalIf(t, err != nil || sh.bucket != "people", "Couldn't select bucket: %v (%q)", err, sh.bucket)
+	err = sh.run("rm")
+	fatalIf(t, err != errUsage, "Expected errUsage for rm without a key, got %v", err)
+	err = sh.run("get a b")
+	fatalIf(t, err != errUsage, "Expected errUsage for get with two keys, got %v", err)
+	err = sh.run("help")
+	fatalIf(t, err != nil || !strings.Contains(out.String(), "use BUCKET"), "No help: %v", err)
+}
+
+func TestHistory(t *testing.T) {
+	sh, out := testShell(t, nil, "")
+	sh.run("use one")
+	sh.run("use two")
+	err := sh.run("!1")
+	fatalIf(t, err != nil || sh.bucket != "one", "!1 didn't re-run 'use one': %v (%q)", err, sh.bucket)
+	fatalIf(t, len(sh.history) != 3 || sh.history[2] != "use one", "Re-run command not added to history: %v", sh.history)
+	for _, bad := range []string{"!0", "!4", "!x"} {
+		err = sh.run(bad)
+		fatalIf(t, err == nil, "%s accepted", bad)
+	}
+	out.Reset()
+	sh.run("history")
+	fatalIf(t, !strings.Contains(out.String(), "   2  use two"), "Wrong history listing: %q", out.String())
+}
+
+func TestPutReplacesSiblings(t *testing.T) {
+	s := riaktest.NewServer()
+	defer s.Close()
+	sh, _ := testShell(t, s, "line one\nline two\n.\n")
+	isTrue := true
+	err := riak.SetBucket(sh.c, "multi", riak.Properties{AllowMulti: &isTrue}, nil)
+	fatalIf(t, err != nil, "Couldn't set bucket: %v", err)
+	for _, v := range []string{"one", "two"} {
+		err = riak.PutItem(sh.c, "multi", "key", []byte(v), nil, nil, nil)
+		fatalIf(t, err != nil, "Couldn't put %s: %v", v, err)
+	}
+	sh.run("use multi")
+	err = sh.run("put key")
+	fatalIf(t, err != nil, "put failed: %v", err)
+	rec, err := riak.FetchRecord(sh.c, "multi", "key")
+	fatalIf(t, err != nil, "Couldn't fetch: %v", err)
+	fatalIf(t, len(rec.Siblings) != 1, "put added a sibling: %d siblings", len(rec.Siblings))
+	fatalIf(t, string(rec.Siblings[0].Value) != "line one\nline two", "Wrong value: %q", rec.Siblings[0].Value)
+}
+
+func TestPutKeepsWhitespace(t *testing.T) {
+	s := riaktest.NewServer()
+	defer s.Close()
+	sh, _ := testShell(t, s, "")
+	sh.run("use bucket")
+	err := sh.run("put  key  {\"a\":\"x  y\tz\"}")
+	fatalIf(t, err != nil, "put failed: %v", err)
+	values, err := s.Get("bucket", "key")
+	fatalIf(t, err != nil || len(values) != 1, "Value not stored: %v", err)
+	fatalIf(t, string(values[0]) != "{\"a\":\"x  y\tz\"}", "Wrong value: %q", values[0])
+}
@@ -0,0 +1,145 @@
+package riaktest
+
+import (
+	"bytes"
+	"github.com/abneptis/riak"
+	"http"
+	"io/ioutil"
+	"json"
+	"mime"
+	"os"
+	"strings"
+)
+
+// MemoryKV is a riak.KV over the same in-memory model as Server, for code
+// that only needs the KV interface:
+//
+//   - writes to allow_mult buckets without the current vclock create siblings
+//   - Get on an item with siblings fails with riak.ErrSiblings
+//   - GetSiblings on an item without siblings fails with riak.ErrUnacceptable
+//     (unless hdrs accepts something other than multipart/mixed)
+//   - If-None-Match: * and If-Match preconditions fail with riak.ErrPreconditionFailed
+//   - missing items fail with riak.ErrUnknownKey
+//
+// The zero value is ready to use.
+type MemoryKV struct {
+	store
+}
+
+func (self *MemoryKV) Ping() os.Error {
+	return nil
+}
+
+// An unknown bucket has the default properties and no keys (and isn't
+// created by asking).
+func (self *MemoryKV) GetBucket(name string, getprops, getkeys bool) (bd riak.BucketDetails, err os.Error) {
+	if getprops {
+		var ob []byte
+		if ob, err = json.Marshal(self.props(name)); err == nil {
+			err = json.Unmarshal(ob, &bd.Props)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if getkeys {
+		bd.Keys = self.keys(name)
+	}
+	return
+}
+
+// Only the properties set in props are changed (as with riak).
+func (self *MemoryKV) SetBucket(name string, props riak.Properties) os.Error {
+	ob, err := json.Marshal(props)
+	if err != nil {
+		return err
+	}
+	in := map[string]interface{}{}
+	if err = json.Unmarshal(ob, &in); err != nil {
+		return err
+	}
+	self.setProps(name, in)
+	return nil
+}
+
+func (self *MemoryKV) ListBuckets() ([]string, os.Error) {
+	return self.bucketNames(), nil
+}
+
+func (self *MemoryKV) ListKeys(bucket string, outch chan<- string) os.Error {
+	for _, k := range self.keys(bucket) {
+		outch <- k
+	}
+	close(outch)
+	return nil
+}
+
+func (self *sibling) response(bucket, vclock string) *http.Response {
+	hdr := siblingHeader(bucket, self)
+	hdr.Set("X-Riak-Vclock", vclock)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    200,
+		Header:        hdr,
+		Body:          ioutil.NopCloser(bytes.NewBuffer(self.value)),
+		ContentLength: int64(len(self.value)),
+	}
+}
+
+func (self *MemoryKV) Get(bucket, key string, hdrs http.Header, parms http.Values) (resp *http.Response, err os.Error) {
+	o := self.object(bucket, key)
+	if o == nil {
+		return nil, riak.ErrUnknownKey
+	}
+	if vtag := parms.Get("vtag"); vtag != "" {
+		for _, s := range o.siblings {
+			if s.vtag == vtag {
+				return s.response(bucket, o.vclock), nil
+			}
+		}
+		return nil, riak.ErrUnknownKey
+	}
+	if len(o.siblings) > 1 {
+		return nil, riak.ErrSiblings
+	}
+	return o.siblings[0].response(bucket, o.vclock), nil
+}
+
+func (self *MemoryKV) GetSiblings(bucket, key string, hdrs http.Header, parms http.Values, respch chan<- *http.Response) (err os.Error) {
+	defer close(respch)
+	o := self.object(bucket, key)
+	if o == nil {
+		return riak.ErrUnknownKey
+	}
+	if len(o.siblings) == 1 {
+		accept := "multipart/mixed"
+		if hdrs != nil && hdrs.Get("Accept") != "" {
+			accept = hdrs.Get("Accept")
+		}
+		mtype, _ := mime.ParseMediaType(o.siblings[0].header.Get("Content-Type"))
+		if !strings.Contains(accept, mtype) && !strings.Contains(accept, "*/*") {
+			return riak.ErrUnacceptable
+		}
+	}
+	for _, s := range o.siblings {
+		respch <- s.response(bucket, o.vclock)
+	}
+	return
+}
+
+func (self *MemoryKV) Put(bucket, key string, body []byte, hdrs http.Header, parms http.Values) os.Error {
+	if hdrs == nil {
+		hdrs = http.Header{"Content-Type": []string{"application/binary"}}
+	}
+	if _, status := self.put(bucket, key, body, hdrs); status != 0 {
+		return riak.ErrPreconditionFailed
+	}
+	return nil
+}
+
+func (self *MemoryKV) Delete(bucket, key string, parms http.Values) os.Error {
+	if found, _ := self.remove(bucket, key); !found {
+		return riak.ErrUnknownKey
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package riaktest
+
+import (
+	"github.com/abneptis/riak"
+	"http"
+	"io/ioutil"
+	"testing"
+)
+
+var _ riak.KV = &MemoryKV{}
+
+func TestMemoryKVPutGetDelete(t *testing.T) {
+	kv := &MemoryKV{}
+	err := kv.Put("bucket", "TestMemoryKV", []byte("hello world"), nil, nil)
+	fatalIf(t, err != nil, "Couldn't put: %v", err)
+	resp, err := kv.Get("bucket", "TestMemoryKV", nil, nil)
+	fatalIf(t, err != nil, "Couldn't get: %v", err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	fatalIf(t, string(body) != "hello world", "Wrong body: %s", body)
+	fatalIf(t, resp.Header.Get("X-Riak-Vclock") == "", "No vclock")
+	fatalIf(t, resp.Header.Get("Content-Type") != "application/binary", "Wrong content-type: %s", resp.Header.Get("Content-Type"))
+	err = kv.Delete("bucket", "TestMemoryKV", nil)
+	fatalIf(t, err != nil, "Couldn't delete: %v", err)
+	_, err = kv.Get("bucket", "TestMemoryKV", nil, nil)
+	fatalIf(t, err != riak.ErrUnknownKey, "Expected ErrUnknownKey, got %v", err)
+	err = kv.Delete("bucket", "TestMemoryKV", nil)
+	fatalIf(t, err != riak.ErrUnknownKey, "Expected ErrUnknownKey, got %v", err)
+}
+
+func TestMemoryKVSiblings(t *testing.T) {
+	kv := &MemoryKV{}
+	isTrue := true
+	kv.SetBucket("multi", riak.Properties{AllowMulti: &isTrue})
+	kv.Put("multi", "TestMemoryKVSiblings", []byte("hello world"), nil, nil)
+	err := kv.GetSiblings("multi", "TestMemoryKVSiblings", nil, nil, make(chan *http.Response))
+	fatalIf(t, err != riak.ErrUnacceptable, "Expected ErrUnacceptable, got %v", err)
+
+	resp, _ := kv.Get("multi", "TestMemoryKVSiblings", nil, nil)
+	// a write with the current vclock replaces the value
+	hdrs := http.Header{"X-Riak-Vclock": []string{resp.Header.Get("X-Riak-Vclock")}}
+	kv.Put("multi", "TestMemoryKVSiblings", []byte("hello new world"), hdrs, nil)
+	_, err = kv.Get("multi", "TestMemoryKVSiblings", nil, nil)
+	fatalIf(t, err != nil, "Unexpected error: %v", err)
+	// ...but a stale one creates a sibling
+	kv.Put("multi", "TestMemoryKVSiblings", []byte("hello world"), hdrs, nil)
+	_, err = kv.Get("multi", "TestMemoryKVSiblings", nil, nil)
+	fatalIf(t, err != riak.ErrSiblings, "Expected ErrSiblings, got %v", err)
+
+	respch := make(chan *http.Response, 2)
+	err = kv.GetSiblings("multi", "TestMemoryKVSiblings", nil, nil, respch)
+	fatalIf(t, err != nil, "Couldn't get siblings: %v", err)
+	n := 0
+	for _ = range respch {
+		n++
+	}
+	fatalIf(t, n != 2, "Wrong number of siblings: %d", n)
+}
+
+func TestMemoryKVPrecondition(t *testing.T) {
+	kv := &MemoryKV{}
+	hdrs := http.Header{"If-None-Match": []string{"*"}}
+	err := kv.Put("bucket", "TestMemoryKVPrecondition", []byte("hello world"), hdrs, nil)
+	fatalIf(t, err != nil, "Couldn't put: %v", err)
+	err = kv.Put("bucket", "TestMemoryKVPrecondition", []byte("hello world"), hdrs, nil)
+	fatalIf(t, err != riak.ErrPreconditionFailed, "Expected ErrPreconditionFailed, got %v", err)
+}
+
+func TestMemoryKVListing(t *testing.T) {
+	kv := &MemoryKV{}
+	kv.Put("bucket", "b", []byte("b"), nil, nil)
+	kv.Put("bucket", "a", []byte("a"), nil, nil)
+	names, err := kv.ListBuckets()
+	fatalIf(t, err != nil || len(names) != 1 || names[0] != "bucket", "Wrong buckets: %v (%v)", names, err)
+	outch := make(chan string, 2)
+	err = kv.ListKeys("bucket", outch)
+	fatalIf(t, err != nil, "Couldn't list keys: %v", err)
+	keys := []string{}
+	for k := range outch {
+		keys = append(keys, k)
+	}
+	fatalIf(t, len(keys) != 2 || keys[0] != "a", "Wrong keys: %v", keys)
+	bd, _ := kv.GetBucket("bucket", true, true)
+	fatalIf(t, bd.Props.Name != "bucket" || bd.Props.NVal != 3, "Wrong props: %v", bd.Props)
+	fatalIf(t, len(bd.Keys) != 2, "Wrong keys: %v", bd.Keys)
+}
+
+func TestMemoryKVUnknownBucket(t *testing.T) {
+	kv := &MemoryKV{}
+	bd, err := kv.GetBucket("unknown", true, true)
+	fatalIf(t, err != nil, "Couldn't get bucket: %v", err)
+	fatalIf(t, bd.Props.NVal != 3 || bd.Props.R == nil || *bd.Props.R != riak.QUORUM, "Wrong default props: %+v", bd.Props)
+	fatalIf(t, len(bd.Keys) != 0, "Wrong keys: %v", bd.Keys)
+	err = kv.ListKeys("unknown", make(chan string))
+	fatalIf(t, err != nil, "Couldn't list keys: %v", err)
+	fatalIf(t, len(kv.buckets) != 0, "Reads created buckets: %v", kv.buckets)
+
+	err = kv.SetBucket("props", riak.Properties{NVal: 5})
+	fatalIf(t, err != nil, "Couldn't set bucket: %v", err)
+	bd, _ = kv.GetBucket("props", true, false)
+	fatalIf(t, bd.Props.NVal != 5 || bd.Props.Name != "props", "Props not set: %+v", bd.Props)
+}
@@ -0,0 +1,565 @@
+// Package riaktest provides an in-process fake of the riak HTTP interface,
+// suitable for testing clients without a running riak node.
+//
+// The fake implements the root resource listing, ping, bucket properties, bucket and key listing
+// (including keys=stream), and object GET/PUT/DELETE with vclocks, siblings
+// (for allow_mult buckets), conditional GETs and the usual 300/304/404/406/412
+// responses.  Faults
+// (latency, error statuses, dropped connections) can be injected per request.
+// Buckets are served under /riak (see SetPrefix), and optionally under the
+// riak 1.0 /buckets URLs as well (see EnableBucketsURLs).  Deleted keys can
+// leave tombstones behind (see KeepTombstones).
+//
+// MemoryKV holds the same model in memory, as a riak.KV that doesn't go
+// through HTTP at all.
+package riaktest
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"http/httptest"
+	"io/ioutil"
+	"json"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bucket names and keys that path.Join, URL parsing or form decoding would
+// mangle if a client sent them as is; handy for testing a client's escaping.
+var TrickyNames = []string{
+	"a/b", "/leading", "trailing/", "a//b", ".", "..", "../up", "a b", "a+b", "100%",
+	"%2F", "q?x=1", "frag#ment", "semi;colon", "amp&ersand", "ünïcödé", "tab\there",
+}
+
+// A Fault alters the handling of a request.
+type Fault struct {
+	Latency int64 // nanoseconds to wait before handling the request
+	Status  int   // if non-zero, respond with this status instead of handling the request
+	Drop    bool  // close the connection without responding
+}
+
+type Server struct {
+	// The base url of the server (e.g., http://127.0.0.1:34567)
+	URL string
+
+	store
+	srv        *httptest.Server
+	prefix     string
+	newURLs    bool
+	webmachine bool
+	faults     []Fault
+	always     *Fault
+	// remote addresses requests have come from
+	clients map[string]bool
+}
+
+// Starts a new fake server; the caller should Close it when finished.
+func NewServer() (s *Server) {
+	s = &Server{prefix: "riak", clients: map[string]bool{}}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return
+}
+
+func (self *Server) Close() {
+	self.srv.Close()
+}
+
+// Serves buckets and objects under /prefix instead of /riak (the root
+// listing advertises it).
+func (self *Server) SetPrefix(prefix string) {
+	self.lock.Lock()
+	self.prefix = prefix
+	self.lock.Unlock()
+}
+
+// Also serves (and advertises) the riak 1.0 URLs: /buckets?buckets=true,
+// /buckets/B/props, /buckets/B/keys and /buckets/B/keys/K.
+func (self *Server) EnableBucketsURLs() {
+	self.lock.Lock()
+	self.newURLs = true
+	self.lock.Unlock()
+}
+
+// Keeps a tombstone for each deleted key until ReapTombstones is called, as
+// riak does for a few seconds after a delete: GETs with deletedvclock=true and
+// repeated DELETEs get the tombstone's vclock with their 404.
+func (self *Server) KeepTombstones() {
+	self.lock.Lock()
+	self.keepTombstones = true
+	self.lock.Unlock()
+}
+
+// Evaluates conditional GETs as riak's webmachine does: If-Modified-Since is
+// still checked when If-None-Match is present but doesn't match.
+func (self *Server) WebmachineConditionals() {
+	self.lock.Lock()
+	self.webmachine = true
+	self.lock.Unlock()
+}
+
+func (self *Server) ReapTombstones() {
+	self.reapTombstones()
+}
+
+// The number of distinct client connections requests have arrived on.
+func (self *Server) Connections() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.clients)
+}
+
+// Applies f to each of the next n requests (after any previously injected faults).
+func (self *Server) InjectFault(f Fault, n int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for i := 0; i < n; i++ {
+		self.faults = append(self.faults, f)
+	}
+}
+
+// Applies f to every request until ClearFaults is called.
+func (self *Server) SetFault(f Fault) {
+	self.lock.Lock()
+	self.always = &f
+	self.lock.Unlock()
+}
+
+func (self *Server) ClearFaults() {
+	self.lock.Lock()
+	self.faults = nil
+	self.always = nil
+	self.lock.Unlock()
+}
+
+func (self *Server) nextFault() (f Fault, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.faults) > 0 {
+		f, ok = self.faults[0], true
+		self.faults = self.faults[1:]
+	} else if self.always != nil {
+		f, ok = *self.always, true
+	}
+	return
+}
+
+func (self *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.lock.Lock()
+	self.clients[r.RemoteAddr] = true
+	self.lock.Unlock()
+	if f, ok := self.nextFault(); ok {
+		if f.Latency > 0 {
+			time.Sleep(f.Latency)
+		}
+		if f.Drop {
+			if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		if f.Status != 0 {
+			http.Error(w, http.StatusText(f.Status), f.Status)
+			return
+		}
+	}
+	qry, _ := http.ParseQuery(r.URL.RawQuery)
+	parts, err := pathSegments(r)
+	if err != nil {
+		http.Error(w, err.String(), http.StatusBadRequest)
+		return
+	}
+	self.lock.Lock()
+	prefix, newURLs := self.prefix, self.newURLs
+	self.lock.Unlock()
+	switch {
+	case len(parts) == 1 && parts[0] == "":
+		self.root(w, r, prefix, newURLs)
+	case len(parts) == 1 && parts[0] == "ping":
+		self.ping(w, r)
+	case parts[0] == "buckets" && newURLs:
+		self.serveBuckets(w, r, parts[1:], qry)
+	case parts[0] != prefix:
+		http.NotFound(w, r)
+	case len(parts) == 1:
+		self.listBuckets(w, r, qry)
+	case len(parts) == 2:
+		self.serveBucket(w, r, parts[1], qry)
+	case len(parts) == 3:
+		self.serveObject(w, r, parts[1], parts[2], qry)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Like riak, names in the path are percent-decoded ('+' included) after
+// splitting it, so they may contain "/".
+func pathSegments(r *http.Request) (parts []string, err os.Error) {
+	raw := r.RawURL
+	if i := strings.Index(raw, "?"); i >= 0 {
+		raw = raw[:i]
+	}
+	if strings.HasPrefix(raw, "http://") {
+		if i := strings.Index(raw[len("http://"):], "/"); i >= 0 {
+			raw = raw[len("http://")+i:]
+		}
+	}
+	parts = strings.Split(strings.Trim(raw, "/"), "/")
+	for i := range parts {
+		if parts[i], err = http.URLUnescape(parts[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Names in listings and links are form-encoded, as riak does.
+func escapeNames(names []string) (out []string) {
+	out = make([]string, len(names))
+	for i, n := range names {
+		out[i] = http.URLEscape(n)
+	}
+	return
+}
+
+func (self *Server) ping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte("OK"))
+}
+
+// Lists the resources as JSON if asked, and as HTML links otherwise (as riak does).
+func (self *Server) root(w http.ResponseWriter, r *http.Request, prefix string, newURLs bool) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := "/" + prefix
+	resources := map[string]string{
+		"riak_kv_wm_buckets":     p,
+		"riak_kv_wm_object":      p,
+		"riak_kv_wm_link_walker": p,
+		"riak_kv_wm_ping":        "/ping",
+	}
+	if newURLs {
+		resources["riak_kv_wm_props"] = "/buckets"
+		resources["riak_kv_wm_keylist"] = "/buckets"
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, resources)
+		return
+	}
+	names := []string{}
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, "<html><body><ul>")
+	for _, name := range names {
+		fmt.Fprintf(w, `<li><a href="%s">%s</a></li>`, resources[name], name)
+	}
+	fmt.Fprint(w, "</ul></body></html>")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	ob, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(ob)))
+	w.WriteHeader(status)
+	w.Write(ob)
+}
+
+func (self *Server) listBuckets(w http.ResponseWriter, r *http.Request, qry http.Values) {
+	if r.Method != "GET" || qry.Get("buckets") != "true" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"buckets": escapeNames(self.bucketNames())})
+}
+
+func (self *Server) serveBucket(w http.ResponseWriter, r *http.Request, name string, qry http.Values) {
+	switch r.Method {
+	case "GET":
+		self.getBucket(w, r, name, qry)
+	case "PUT":
+		self.setBucket(w, r, name)
+	case "POST":
+		self.createObject(w, r, name, qry, false)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// parts are the path elements after /buckets
+func (self *Server) serveBuckets(w http.ResponseWriter, r *http.Request, parts []string, qry http.Values) {
+	switch {
+	case len(parts) == 0:
+		self.listBuckets(w, r, qry)
+	case len(parts) == 2 && parts[1] == "props" && r.Method != "POST":
+		qry.Del("keys")
+		self.serveBucket(w, r, parts[0], qry)
+	case len(parts) == 2 && parts[1] == "keys" && r.Method == "GET":
+		qry.Set("props", "false")
+		self.getBucket(w, r, parts[0], qry)
+	case len(parts) == 2 && parts[1] == "keys" && r.Method == "POST":
+		self.createObject(w, r, parts[0], qry, true)
+	case len(parts) == 3 && parts[1] == "keys":
+		self.serveObject(w, r, parts[0], parts[2], qry)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (self *Server) getBucket(w http.ResponseWriter, r *http.Request, name string, qry http.Values) {
+	out := map[string]interface{}{}
+	if qry.Get("props") != "false" {
+		out["props"] = self.props(name)
+	}
+	switch qry.Get("keys") {
+	case "true":
+		out["keys"] = escapeNames(self.keys(name))
+	case "stream":
+		// riak streams a sequence of json objects; we send one key per chunk
+		// to make sure clients handle more than one.
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		if len(out) > 0 {
+			enc.Encode(out)
+		}
+		for _, k := range escapeNames(self.keys(name)) {
+			enc.Encode(map[string][]string{"keys": []string{k}})
+		}
+		enc.Encode(map[string][]string{"keys": []string{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (self *Server) setBucket(w http.ResponseWriter, r *http.Request, name string) {
+	in := map[string]map[string]interface{}{}
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if err != nil || in["props"] == nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	self.setProps(name, in["props"])
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (self *Server) serveObject(w http.ResponseWriter, r *http.Request, bname, key string, qry http.Values) {
+	switch r.Method {
+	case "GET", "HEAD":
+		self.getObject(w, r, bname, key, qry)
+	case "PUT":
+		self.putObject(w, r, bname, key, qry, "")
+	case "DELETE":
+		self.deleteObject(w, r, bname, key)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (self *Server) getObject(w http.ResponseWriter, r *http.Request, bname, key string, qry http.Values) {
+	o := self.object(bname, key)
+	if o == nil {
+		if vclock := self.tombstone(bname, key); vclock != "" && qry.Get("deletedvclock") == "true" {
+			w.Header().Set("X-Riak-Vclock", vclock)
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	self.lock.Lock()
+	webmachine := self.webmachine
+	self.lock.Unlock()
+	if len(o.siblings) == 1 && notModified(r, o.siblings[0], webmachine) {
+		s := o.siblings[0]
+		w.Header().Set("X-Riak-Vclock", o.vclock)
+		w.Header().Set("Etag", s.vtag)
+		w.Header().Set("Last-Modified", time.SecondsToUTC(s.modified).Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	self.writeObject(w, r, bname, o, http.StatusOK, qry.Get("vtag"))
+}
+
+// If-None-Match takes precedence over If-Modified-Since, as in RFC 2616,
+// unless webmachine is set (see WebmachineConditionals).
+func notModified(r *http.Request, s *sibling, webmachine bool) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			if tag = strings.Trim(strings.TrimSpace(tag), "\""); tag == s.vtag || tag == "*" {
+				return true
+			}
+		}
+		if !webmachine {
+			return false
+		}
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if t, err := time.Parse(http.TimeFormat, ims); err == nil && s.modified <= t.Seconds() {
+			return true
+		}
+	}
+	return false
+}
+
+func accepts(r *http.Request, ctype string) bool {
+	mtype, _ := mime.ParseMediaType(ctype)
+	accept := r.Header.Get("Accept")
+	return accept == "" || strings.Contains(accept, mtype) || strings.Contains(accept, "*/*")
+}
+
+func (self *Server) writeObject(w http.ResponseWriter, r *http.Request, bname string, o *object, status int, vtag string) {
+	w.Header().Set("X-Riak-Vclock", o.vclock)
+	if vtag != "" {
+		for _, s := range o.siblings {
+			if s.vtag == vtag {
+				o = &object{vclock: o.vclock, siblings: []*sibling{s}}
+				break
+			}
+		}
+		if len(o.siblings) != 1 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+	}
+	if len(o.siblings) > 1 {
+		self.writeSiblings(w, r, bname, o)
+		return
+	}
+	if !accepts(r, o.siblings[0].header.Get("Content-Type")) {
+		http.Error(w, "Not acceptable", http.StatusNotAcceptable)
+		return
+	}
+	s := o.siblings[0]
+	for k, v := range siblingHeader(bname, s) {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(s.value)))
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		w.Write(s.value)
+	}
+}
+
+func (self *Server) writeSiblings(w http.ResponseWriter, r *http.Request, bname string, o *object) {
+	buff := bytes.NewBuffer(nil)
+	if accepts(r, "multipart/mixed") {
+		mw := multipart.NewWriter(buff)
+		for _, s := range o.siblings {
+			pw, err := mw.CreatePart(textproto.MIMEHeader(siblingHeader(bname, s)))
+			if err == nil {
+				_, err = pw.Write(s.value)
+			}
+			if err != nil {
+				http.Error(w, err.String(), http.StatusInternalServerError)
+				return
+			}
+		}
+		mw.Close()
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	} else {
+		buff.WriteString("Siblings:\n")
+		for _, s := range o.siblings {
+			buff.WriteString(s.vtag + "\n")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buff.Len()))
+	w.WriteHeader(http.StatusMultipleChoices)
+	if r.Method != "HEAD" {
+		w.Write(buff.Bytes())
+	}
+}
+
+// POSTing to a bucket stores the value under a new key, returned in Location.
+func (self *Server) createObject(w http.ResponseWriter, r *http.Request, bname string, qry http.Values, newURLs bool) {
+	self.lock.Lock()
+	key := "riaktest" + self.nextId()
+	location := "/" + self.prefix + "/" + http.URLEscape(bname) + "/" + key
+	self.lock.Unlock()
+	if newURLs {
+		location = "/buckets/" + http.URLEscape(bname) + "/keys/" + key
+	}
+	self.putObject(w, r, bname, key, qry, location)
+}
+
+// If location is set, the object was created by a POST.
+func (self *Server) putObject(w http.ResponseWriter, r *http.Request, bname, key string, qry http.Values, location string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.String(), http.StatusBadRequest)
+		return
+	}
+	o, status := self.put(bname, key, body, r.Header)
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	if qry.Get("returnbody") == "true" {
+		status = http.StatusOK
+		if location != "" {
+			status = http.StatusCreated
+		}
+		self.writeObject(w, r, bname, o, status, "")
+		return
+	}
+	if location != "" {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (self *Server) deleteObject(w http.ResponseWriter, r *http.Request, bname, key string) {
+	found, vclock := self.remove(bname, key)
+	if !found {
+		if vclock != "" {
+			w.Header().Set("X-Riak-Vclock", vclock)
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Stores value directly (bypassing HTTP), e.g. to seed a test.
+func (self *Server) Put(bname, key, ctype string, value []byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	hdr := http.Header{}
+	hdr.Set("Content-Type", ctype)
+	s := &sibling{value: value, header: hdr, vtag: self.nextId(), modified: time.Seconds()}
+	self.bucket(bname).objects[key] = &object{vclock: self.newVclock(bname, key), siblings: []*sibling{s}}
+}
+
+// Returns the values of all siblings stored at bname/key.
+func (self *Server) Get(bname, key string) (values [][]byte, err os.Error) {
+	o := self.object(bname, key)
+	if o == nil {
+		return nil, os.NewError("not found")
+	}
+	for _, s := range o.siblings {
+		values = append(values, s.value)
+	}
+	return
+}
@@ -0,0 +1,576 @@
+package riaktest
+
+import (
+	"github.com/abneptis/riak"
+	"http"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func fatalIf(t *testing.T, test bool, s string, v ...interface{}) {
+	if test {
+		t.Fatalf(s, v...)
+	}
+}
+
+func testClient(t *testing.T, s *Server) (c riak.Client) {
+	u, err := http.ParseURL(s.URL)
+	fatalIf(t, err != nil, "Couldn't parse server url: %v", err)
+	c, err = riak.NewClient("riaktest", *u)
+	fatalIf(t, err != nil, "Couldn't create client: %v", err)
+	return
+}
+
+func TestPing(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	err := riak.Ping(c, nil)
+	fatalIf(t, err != nil, "Couldn't ping: %v", err)
+}
+
+func TestBucketProps(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	isTrue := true
+	err := riak.SetBucket(c, "bucket", riak.Properties{NVal: 5, AllowMulti: &isTrue}, nil)
+	fatalIf(t, err != nil, "Couldn't set bucket: %v", err)
+	bd, err := riak.GetBucket(c, "bucket", true, false, nil)
+	fatalIf(t, err != nil, "Couldn't get bucket: %v", err)
+	fatalIf(t, bd.Props.Name != "bucket", "Wrong name: %s", bd.Props.Name)
+	fatalIf(t, bd.Props.AllowMulti == nil || !*bd.Props.AllowMulti, "allow_mult not set")
+	fatalIf(t, bd.Props.NVal != 5, "n_val not set: %d", bd.Props.NVal)
+}
+
+func TestPutGetDelete(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	err := riak.PutItem(c, "bucket", "key", []byte("hello world"), nil, nil, nil)
+	fatalIf(t, err != nil, "Couldn't put: %v", err)
+	resp, err := riak.GetItem(c, "bucket", "key", nil, nil, nil)
+	fatalIf(t, err != nil, "Couldn't get: %v", err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	fatalIf(t, string(body) != "hello world", "Wrong body: %s", body)
+	fatalIf(t, resp.Header.Get("X-Riak-Vclock") == "", "No vclock")
+	err = riak.DeleteItem(c, "bucket", "key", nil, nil)
+	fatalIf(t, err != nil, "Couldn't delete: %v", err)
+	_, err = riak.GetItem(c, "bucket", "key", nil, nil, nil)
+	fatalIf(t, err != riak.ErrUnknownKey, "Expected ErrUnknownKey, got %v", err)
+	err = riak.DeleteItem(c, "bucket", "key", nil, nil)
+	fatalIf(t, err != riak.ErrUnknownKey, "Expected ErrUnknownKey, got %v", err)
+}
+
+func TestSiblings(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	isTrue := true
+	err := riak.SetBucket(c, "multi", riak.Properties{AllowMulti: &isTrue}, nil)
+	fatalIf(t, err != nil, "Couldn't set bucket: %v", err)
+	err = riak.PutItem(c, "multi", "key", []byte("one"), nil, nil, nil)
+	fatalIf(t, err != nil, "Couldn't put: %v", err)
+	err = riak.GetMultiItem(c, "multi", "key", nil, nil, make(chan *http.Response), nil)
+	fatalIf(t, err != riak.ErrUnacceptable, "Expected ErrUnacceptable for a single value, got %v", err)
+
+	err = riak.PutItem(c, "multi", "key", []byte("two"), nil, nil, nil)
+	fatalIf(t, err != nil, "Couldn't put: %v", err)
+	_, err = riak.GetItem(c, "multi", "key", nil, nil, nil)
+	fatalIf(t, err != riak.ErrSiblings, "Expected ErrSiblings from GetItem, got %v", err)
+	respch := make(chan *http.Response)
+	found := map[string]bool{}
+	done := make(chan int)
+	go func() {
+		for resp := range respch {
+			body, _ := ioutil.ReadAll(resp.Body)
+			found[string(body)] = true
+		}
+		done <- 1
+	}()
+	err = riak.GetMultiItem(c, "multi", "key", nil, nil, respch, nil)
+	fatalIf(t, err != nil, "Couldn't get siblings: %v", err)
+	<-done
+	fatalIf(t, !found["one"] || !found["two"] || len(found) != 2, "Wrong siblings: %v", found)
+}
+
+func TestListKeys(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	s.Put("bucket", "a", "text/plain", []byte("a"))
+	s.Put("bucket", "b", "text/plain", []byte("b"))
+	keys := []string{}
+	ch := make(chan string)
+	done := make(chan int)
+	go func() {
+		for k := range ch {
+			keys = append(keys, k)
+		}
+		done <- 1
+	}()
+	err := riak.ListKeys(c, "bucket", ch, nil)
+	fatalIf(t, err != nil, "Couldn't list keys: %v", err)
+	<-done
+	fatalIf(t, len(keys) != 2, "Wrong keys: %v", keys)
+	names, err := riak.ListBuckets(c, nil)
+	fatalIf(t, err != nil || len(names) != 1 || names[0] != "bucket", "Wrong buckets: %v (%v)", names, err)
+}
+
+func TestPreconditionFailed(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	s.Put("bucket", "key", "text/plain", []byte("hello"))
+	err := riak.PutItem(c, "bucket", "key", []byte("hello"), http.Header{"If-None-Match": []string{"*"}}, nil, nil)
+	fatalIf(t, err != riak.ErrPreconditionFailed, "Expected ErrPreconditionFailed, got %v", err)
+}
+
+func TestFaults(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	s.InjectFault(Fault{Status: http.StatusServiceUnavailable}, 1)
+	err := riak.Ping(c, nil)
+	fatalIf(t, err == nil, "Expected an error from injected 503")
+	err = riak.Ping(c, nil)
+	fatalIf(t, err != nil, "Fault should only apply once: %v", err)
+	s.SetFault(Fault{Drop: true})
+	err = riak.Ping(c, nil)
+	fatalIf(t, err == nil, "Expected an error from dropped connection")
+	s.ClearFaults()
+	err = riak.Ping(c, nil)
+	fatalIf(t, err != nil, "Faults should be cleared: %v", err)
+}
+
+func TestDiscover(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.SetPrefix("kv")
+	s.Put("bucket", "key", "text/plain", []byte("hello"))
+	c := testClient(t, s)
+	_, err := riak.GetItem(c, "bucket", "key", nil, nil, nil)
+	fatalIf(t, err != riak.ErrUnknownKey, "Expected ErrUnknownKey under /riak, got %v", err)
+	res, err := riak.Discover(c, nil)
+	fatalIf(t, err != nil, "Couldn't discover: %v", err)
+	fatalIf(t, res[riak.ResourceObject] != "/kv", "Wrong object resource: %v", res)
+	resp, err := riak.GetItem(c.WithResources(res), "bucket", "key", nil, nil, nil)
+	fatalIf(t, err != nil, "Couldn't get via discovered path: %v", err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	fatalIf(t, string(body) != "hello", "Wrong value: %s", body)
+}
+
+func TestBucketsURLs(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.EnableBucketsURLs()
+	c := testClient(t, s)
+	res, err := riak.Discover(c, nil)
+	fatalIf(t, err != nil, "Couldn't discover: %v", err)
+	c = c.WithResources(res)
+	err = riak.PutItem(c, "bucket", "key", []byte("hello"), nil, nil, nil)
+	fatalIf(t, err != nil, "Couldn't put: %v", err)
+	values, err := s.Get("bucket", "key")
+	fatalIf(t, err != nil || len(values) != 1, "Put didn't reach the fake: %v", err)
+	bd, err := riak.GetBucket(c, "bucket", true, true, nil)
+	fatalIf(t, err != nil, "Couldn't get bucket: %v", err)
+	fatalIf(t, bd.Props.NVal != 3 || len(bd.Keys) != 1 || bd.Keys[0] != "key", "Wrong bucket details: %v", bd)
+	names, err := riak.ListBuckets(c, nil)
+	fatalIf(t, err != nil || len(names) != 1, "Wrong buckets: %v (%v)", names, err)
+	err = riak.DeleteItem(c, "bucket", "key", nil, nil)
+	fatalIf(t, err != nil, "Couldn't delete: %v", err)
+}
+
+func TestTrickyKeys(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	bname := "my bucket/+"
+	for _, k := range TrickyNames {
+		err := riak.PutItem(c, bname, k, []byte(k), nil, nil, nil)
+		fatalIf(t, err != nil, "Couldn't put %q: %v", k, err)
+		values, err := s.Get(bname, k)
+		fatalIf(t, err != nil || len(values) != 1 || string(values[0]) != k, "%q stored under the wrong key (%v)", k, err)
+		resp, err := riak.GetItem(c, bname, k, nil, nil, nil)
+		fatalIf(t, err != nil, "Couldn't get %q: %v", k, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		fatalIf(t, string(body) != k, "Got %q for %q", body, k)
+	}
+
+	found := map[string]bool{}
+	ch := make(chan string)
+	done := make(chan int)
+	go func() {
+		for k := range ch {
+			found[k] = true
+		}
+		done <- 1
+	}()
+	err := riak.ListKeys(c, bname, ch, nil)
+	<-done
+	fatalIf(t, err != nil, "Couldn't list keys: %v", err)
+	for _, k := range TrickyNames {
+		fatalIf(t, !found[k], "%q missing from the key list: %v", k, found)
+	}
+	names, err := riak.ListBuckets(c, nil)
+	fatalIf(t, err != nil || len(names) != 1 || names[0] != bname, "Wrong buckets: %v (%v)", names, err)
+
+	for _, k := range TrickyNames {
+		err = riak.DeleteItem(c, bname, k, nil, nil)
+		fatalIf(t, err != nil, "Couldn't delete %q: %v", k, err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	key, resp, err := riak.Create(c, "bucket", []byte("one"), riak.CreateOptions{}, nil)
+	fatalIf(t, err != nil, "Couldn't create: %v", err)
+	fatalIf(t, key == "" || resp != nil, "Unexpected result: %q %v", key, resp)
+	values, err := s.Get("bucket", key)
+	fatalIf(t, err != nil || string(values[0]) != "one", "Created value not stored under %q", key)
+
+	s.EnableBucketsURLs()
+	opts := riak.CreateOptions{ReturnBody: true, Header: http.Header{"Content-Type": []string{"text/plain"}}}
+	key2, resp, err := riak.Create(c.WithURLScheme(riak.URLSchemeBuckets), "bucket", []byte("two"), opts, nil)
+	fatalIf(t, err != nil, "Couldn't create with returnbody: %v", err)
+	fatalIf(t, key2 == key, "Keys should be unique: %q", key2)
+	fatalIf(t, resp == nil || resp.StatusCode != http.StatusCreated, "Expected the object with a 201, got %v", resp)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	fatalIf(t, string(body) != "two" || resp.Header.Get("X-Riak-Vclock") == "", "Wrong returned object: %q %v", body, resp.Header)
+}
+
+// Returns the bodies and vclock of the objects sent on respch by put.
+func collectBodies(put func(respch chan<- *http.Response) os.Error) (bodies map[string]bool, vclock string, err os.Error) {
+	respch := make(chan *http.Response)
+	bodies = map[string]bool{}
+	done := make(chan int)
+	go func() {
+		for resp := range respch {
+			body, _ := ioutil.ReadAll(resp.Body)
+			bodies[string(body)] = true
+			vclock = resp.Header.Get("X-Riak-Vclock")
+		}
+		done <- 1
+	}()
+	err = put(respch)
+	<-done
+	return
+}
+
+func TestPutItemReturnBody(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	isTrue := true
+	err := riak.SetBucket(c, "multi", riak.Properties{AllowMulti: &isTrue}, nil)
+	fatalIf(t, err != nil, "Couldn't set bucket: %v", err)
+
+	bodies, vclock, err := collectBodies(func(respch chan<- *http.Response) os.Error {
+		return riak.PutItemReturnBody(c, "multi", "key", []byte("one"), nil, nil, respch, nil)
+	})
+	fatalIf(t, err != nil, "Couldn't put: %v", err)
+	fatalIf(t, len(bodies) != 1 || !bodies["one"] || vclock == "", "Wrong returned object: %v %q", bodies, vclock)
+
+	// no vclock, so this creates a sibling
+	bodies, vclock2, err := collectBodies(func(respch chan<- *http.Response) os.Error {
+		return riak.PutItemReturnBody(c, "multi", "key", []byte("two"), nil, nil, respch, nil)
+	})
+	fatalIf(t, err != nil, "Couldn't put: %v", err)
+	fatalIf(t, len(bodies) != 2 || !bodies["one"] || !bodies["two"], "Wrong returned siblings: %v", bodies)
+	fatalIf(t, vclock2 == "" || vclock2 == vclock, "Vclock not updated: %q", vclock2)
+
+	// the returned vclock resolves the siblings
+	hdrs := http.Header{"Content-Type": []string{"text/plain"}, "X-Riak-Vclock": []string{vclock2}}
+	err = riak.PutItem(c, "multi", "key", []byte("three"), hdrs, http.Values{"returnbody": []string{"true"}}, nil)
+	fatalIf(t, err != nil, "PutItem with returnbody failed: %v", err)
+	values, err := s.Get("multi", "key")
+	fatalIf(t, err != nil || len(values) != 1 || string(values[0]) != "three", "Siblings not resolved: %q", values)
+}
+
+func TestExistsAndConditionalGet(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	exists, err := riak.Exists(c, "bucket", "key", nil, nil)
+	fatalIf(t, err != nil || exists, "Missing key exists? %v (%v)", exists, err)
+	s.Put("bucket", "key", "text/plain", []byte("hello"))
+	exists, err = riak.Exists(c, "bucket", "key", nil, nil)
+	fatalIf(t, err != nil || !exists, "Key doesn't exist? %v (%v)", exists, err)
+
+	resp, err := riak.GetItem(c, "bucket", "key", nil, nil, nil)
+	fatalIf(t, err != nil, "Couldn't get: %v", err)
+	resp.Body.Close()
+	v := riak.ResponseValidators(resp)
+	_, err = riak.GetItemIfChanged(c, "bucket", "key", v, nil, nil, nil)
+	fatalIf(t, err != riak.ErrNotModified, "Expected ErrNotModified (etag), got %v", err)
+	_, err = riak.GetItemIfChanged(c, "bucket", "key", riak.Validators{LastModified: v.LastModified}, nil, nil, nil)
+	fatalIf(t, err != riak.ErrNotModified, "Expected ErrNotModified (last-modified), got %v", err)
+
+	s.Put("bucket", "key", "text/plain", []byte("changed"))
+	resp, err = riak.GetItemIfChanged(c, "bucket", "key", riak.Validators{ETag: v.ETag}, nil, nil, nil)
+	fatalIf(t, err != nil, "Changed value should be fetched: %v", err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	fatalIf(t, string(body) != "changed", "Wrong value: %s", body)
+}
+
+func TestConditionalGetSameSecond(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WebmachineConditionals()
+	c := testClient(t, s)
+	s.Put("bucket", "key", "text/plain", []byte("hello"))
+	resp, err := riak.GetItem(c, "bucket", "key", nil, nil, nil)
+	fatalIf(t, err != nil, "Couldn't get: %v", err)
+	resp.Body.Close()
+	v := riak.ResponseValidators(resp)
+	s.Put("bucket", "key", "text/plain", []byte("changed"))
+	// as though the rewrite happened within the same second
+	v.LastModified = time.Seconds()
+
+	hdrs := http.Header{"If-None-Match": []string{v.ETag}, "If-Modified-Since": []string{time.SecondsToUTC(v.LastModified).Format(http.TimeFormat)}}
+	_, err = riak.GetItem(c, "bucket", "key", hdrs, nil, nil)
+	fatalIf(t, err != riak.ErrNotModified, "Expected webmachine's stale ErrNotModified with both headers, got %v", err)
+
+	resp, err = riak.GetItemIfChanged(c, "bucket", "key", v, nil, nil, nil)
+	fatalIf(t, err != nil, "Same-second rewrite not fetched: %v", err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	fatalIf(t, string(body) != "changed", "Wrong value: %s", body)
+}
+
+func TestDeleteTombstones(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.KeepTombstones()
+	c := testClient(t, s)
+	s.Put("bucket", "key", "text/plain", []byte("hello"))
+	resp, err := riak.GetItem(c, "bucket", "key", nil, nil, nil)
+	fatalIf(t, err != nil, "Couldn't get: %v", err)
+	resp.Body.Close()
+
+	opts := riak.DeleteOptions{Vclock: resp.Header.Get("X-Riak-Vclock"), Verify: true}
+	res, err := riak.Delete(c, "bucket", "key", opts, nil)
+	fatalIf(t, err != nil, "Couldn't delete: %v", err)
+	fatalIf(t, !res.Deleted || !res.Tombstone || res.Reaped || res.Vclock == "", "Expected a tombstone: %+v", res)
+
+	res, err = riak.Delete(c, "bucket", "key", riak.DeleteOptions{}, nil)
+	fatalIf(t, err != nil, "Deleting a tombstone failed: %v", err)
+	fatalIf(t, res.Deleted || !res.Tombstone, "Expected only a tombstone: %+v", res)
+
+	s.ReapTombstones()
+	s.Put("bucket", "key", "text/plain", []byte("hello"))
+	res, err = riak.Delete(c, "bucket", "key", opts, nil)
+	s.ReapTombstones()
+	fatalIf(t, err != nil || !res.Deleted, "Couldn't delete again: %+v (%v)", res, err)
+	res, err = riak.Delete(c, "bucket", "key", riak.DeleteOptions{}, nil)
+	fatalIf(t, err != nil || res.Deleted || res.Tombstone, "Expected nothing at all: %+v (%v)", res, err)
+}
+
+func TestDeleteVerifyWaitsForReap(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.KeepTombstones()
+	c := testClient(t, s)
+	s.Put("bucket", "key", "text/plain", []byte("hello"))
+	opts := riak.DeleteOptions{Verify: true, VerifyTimeout: 5e9, VerifyInterval: 1e7}
+	go func() {
+		time.Sleep(1e8)
+		s.ReapTombstones()
+	}()
+	start := time.Nanoseconds()
+	res, err := riak.Delete(c, "bucket", "key", opts, nil)
+	fatalIf(t, err != nil, "Couldn't delete: %v", err)
+	fatalIf(t, !res.Deleted || !res.Reaped || res.Tombstone, "Expected the tombstone to be reaped: %+v", res)
+	fatalIf(t, time.Nanoseconds()-start > 2e9, "Verify didn't notice the reap")
+
+	// not reaped in time
+	s.Put("bucket", "key", "text/plain", []byte("hello"))
+	opts.VerifyTimeout = 5e7
+	res, err = riak.Delete(c, "bucket", "key", opts, nil)
+	fatalIf(t, err != nil, "Couldn't delete: %v", err)
+	fatalIf(t, res.Reaped || !res.Tombstone || res.Vclock == "", "Expected the tombstone to remain: %+v", res)
+
+	// the client's context bounds the wait
+	s.Put("bucket", "key", "text/plain", []byte("hello"))
+	ctx := riak.NewTimeoutContext(1e8)
+	defer ctx.Stop()
+	opts.VerifyTimeout = 5e9
+	_, err = riak.Delete(c.WithContext(ctx), "bucket", "key", opts, nil)
+	fatalIf(t, err != riak.ErrTimeout, "Expected ErrTimeout, got %v", err)
+}
+
+func TestBatchConnections(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	var objs []riak.Object
+	var keys []riak.BucketKey
+	for i := 0; i < 50; i++ {
+		k := "key" + strconv.Itoa(i)
+		objs = append(objs, riak.Object{Bucket: "bucket", Key: k, Value: []byte(k)})
+		keys = append(keys, riak.BucketKey{"bucket", k}, riak.BucketKey{"bucket", "missing" + k})
+	}
+	_, stats := riak.MultiPut(c, objs, riak.BatchOptions{Workers: 3})
+	fatalIf(t, stats.Succeeded != 50, "Wrong put stats: %+v", stats)
+	fatalIf(t, s.Connections() > 3, "Puts used %d connections", s.Connections())
+
+	before := s.Connections()
+	_, stats = riak.MultiGet(c, keys, riak.BatchOptions{Workers: 3})
+	fatalIf(t, stats.Succeeded != 50 || stats.NotFound != 50, "Wrong get stats: %+v", stats)
+	fatalIf(t, s.Connections()-before > 3, "Gets used %d connections", s.Connections()-before)
+}
+
+func TestBackupConnections(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	for i := 0; i < 20; i++ {
+		s.Put("bucket", "key"+strconv.Itoa(i), "text/plain", []byte("hello"))
+	}
+	before := s.Connections()
+	stats, err := riak.Backup(c, []string{"bucket"}, ioutil.Discard, riak.BackupOptions{})
+	fatalIf(t, err != nil || stats.Keys != 20, "Backup failed: %v (%+v)", err, stats)
+	// one for the listing, one for the keys
+	fatalIf(t, s.Connections()-before > 2, "Backup used %d connections", s.Connections()-before)
+}
+
+// Accepts n bytes, then fails.
+type shortWriter struct {
+	n int
+}
+
+func (self *shortWriter) Write(p []byte) (int, os.Error) {
+	if len(p) > self.n {
+		n := self.n
+		self.n = 0
+		return n, os.NewError("disk full")
+	}
+	self.n -= len(p)
+	return len(p), nil
+}
+
+func TestBackupWriteErrors(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	for i := 0; i < 300; i++ {
+		s.Put("bucket", "key"+strconv.Itoa(i), "text/plain", []byte("hello"))
+	}
+	stats, err := riak.Backup(c, []string{"bucket"}, &shortWriter{}, riak.BackupOptions{})
+	fatalIf(t, err == nil || stats.Errors != 1, "Write error not reported: %v (%+v)", err, stats)
+	// just the gzip header fits; the rest may only be written when the stream is closed
+	_, err = riak.Backup(c, []string{"bucket"}, &shortWriter{10}, riak.BackupOptions{Gzip: true})
+	fatalIf(t, err == nil, "Truncated gzip backup reported success")
+}
+
+func TestCancelWaitingForResponse(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	s.Put("bucket", "key", "text/plain", []byte("hello"))
+	s.InjectFault(Fault{Latency: 2e9}, 1)
+	ctx := riak.NewContext()
+	time.AfterFunc(50e6, func() { ctx.Cancel() })
+	start := time.Nanoseconds()
+	respch := make(chan *http.Response, 1)
+	err := riak.GetMultiItem(c.WithContext(ctx), "bucket", "key", nil, nil, respch, nil)
+	fatalIf(t, err != riak.ErrCanceled, "Expected ErrCanceled, got %v", err)
+	fatalIf(t, time.Nanoseconds()-start > 1e9, "Cancel didn't close the connection")
+	_, ok := <-respch
+	fatalIf(t, ok, "GetMultiItem didn't close its channel")
+}
+
+func TestCancelListKeysMidStream(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	for i := 0; i < 100; i++ {
+		s.Put("bucket", "key"+strconv.Itoa(i), "text/plain", []byte("hello"))
+	}
+	ctx := riak.NewContext()
+	keys := make(chan string)
+	done := make(chan os.Error)
+	go func() { done <- riak.ListKeys(c.WithContext(ctx), "bucket", keys, nil) }()
+	<-keys
+	// nobody reads the rest: ListKeys is blocked on the channel (or the stream)
+	ctx.Cancel()
+	select {
+	case err := <-done:
+		fatalIf(t, err != riak.ErrCanceled, "Expected ErrCanceled, got %v", err)
+	case <-time.After(1e9):
+		t.Fatalf("Cancel didn't stop ListKeys")
+	}
+	for _ = range keys {
+	}
+}
+
+// Server-side connection goroutines only exit once the client closes its end,
+// so the goroutine count also covers connections left open.
+func TestRequestsDontLeak(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	ctx := riak.NewContext()
+	defer ctx.Cancel()
+	riak.Ping(c.WithContext(ctx), nil)
+	time.Sleep(1e8)
+	before := runtime.Goroutines()
+	for i := 0; i < 20; i++ {
+		err := riak.Ping(c.WithContext(ctx), nil)
+		fatalIf(t, err != nil, "Ping failed: %v", err)
+		err = riak.Ping(c, nil)
+		fatalIf(t, err != nil, "Ping failed: %v", err)
+	}
+	time.Sleep(1e8)
+	fatalIf(t, runtime.Goroutines() > before+2, "%d goroutines before the pings, %d after", before, runtime.Goroutines())
+}
+
+func TestCancelAfterRequestKeepsConn(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	u, _ := http.ParseURL(s.URL)
+	conn, err := net.Dial("tcp", u.Host)
+	fatalIf(t, err != nil, "Couldn't dial: %v", err)
+	cc := http.NewClientConn(conn, nil)
+	defer cc.Close()
+	ctx := riak.NewContext()
+	err = riak.Ping(c.WithContext(ctx), cc)
+	fatalIf(t, err != nil, "Ping failed: %v", err)
+	ctx.Cancel()
+	time.Sleep(1e7)
+	err = riak.Ping(c, cc)
+	fatalIf(t, err != nil, "Connection closed by a context canceled after its request: %v", err)
+}
+
+func TestCassetteRecordingUsesContext(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := testClient(t, s)
+	c.Cassette = &riak.Cassette{Mode: riak.CassetteRecord}
+	s.InjectFault(Fault{Latency: 2e9}, 1)
+	ctx := riak.NewContext()
+	time.AfterFunc(50e6, func() { ctx.Cancel() })
+	start := time.Nanoseconds()
+	err := riak.Ping(c.WithContext(ctx), nil)
+	fatalIf(t, err != riak.ErrCanceled, "Expected ErrCanceled, got %v", err)
+	fatalIf(t, time.Nanoseconds()-start > 1e9, "Cancel ignored while recording")
+
+	hdrs := http.Header{"X-Riak-Meta-A": []string{"1"}}
+	err = riak.PutItem(c, "bucket", "key", []byte("v"), hdrs, nil, nil)
+	fatalIf(t, err != nil, "PutItem failed: %v", err)
+	hdrs.Set("X-Riak-Meta-A", "2")
+	rec := c.Cassette.Interactions[len(c.Cassette.Interactions)-1].Request
+	fatalIf(t, rec.Header.Get("X-Riak-Meta-A") != "1", "Recorded header changed with the caller's: %v", rec.Header)
+}
@@ -0,0 +1,258 @@
+package riaktest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The buckets and objects held by a Server or a MemoryKV, with riak's rules
+// for vclocks, siblings and write preconditions.
+
+type sibling struct {
+	value    []byte
+	header   http.Header
+	vtag     string
+	modified int64
+}
+
+type object struct {
+	vclock   string
+	siblings []*sibling
+}
+
+type bucket struct {
+	props   map[string]interface{}
+	objects map[string]*object
+	// vclocks of deleted keys (see KeepTombstones)
+	tombstones map[string]string
+}
+
+type store struct {
+	lock           sync.Mutex
+	buckets        map[string]*bucket
+	counter        int
+	keepTombstones bool
+}
+
+func defaultProps(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":            name,
+		"n_val":           3,
+		"allow_mult":      false,
+		"last_write_wins": false,
+		"precommit":       []string{},
+		"postcommit":      []string{},
+		"r":               "quorum",
+		"w":               "quorum",
+		"dw":              "quorum",
+		"rw":              "quorum",
+		"old_vclock":      86400,
+		"young_vclock":    20,
+		"big_vclock":      50,
+		"small_vclock":    10,
+	}
+}
+
+// Returns the named bucket, creating it if need be; for writes only, so that
+// reading an unknown bucket doesn't create it.  Must be called with the lock
+// held.
+func (self *store) bucket(name string) (b *bucket) {
+	if self.buckets == nil {
+		self.buckets = map[string]*bucket{}
+	}
+	b, ok := self.buckets[name]
+	if !ok {
+		b = &bucket{props: defaultProps(name), objects: map[string]*object{}, tombstones: map[string]string{}}
+		self.buckets[name] = b
+	}
+	return
+}
+
+// must be called with the lock held
+func (self *store) nextId() string {
+	self.counter++
+	return strconv.Itoa(self.counter)
+}
+
+// must be called with the lock held
+func (self *store) newVclock(bname, key string) string {
+	return base64.StdEncoding.EncodeToString([]byte("riaktest:" + bname + "/" + key + ":" + self.nextId()))
+}
+
+// The bucket's properties (the defaults for an unknown bucket).
+func (self *store) props(name string) (props map[string]interface{}) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	b, ok := self.buckets[name]
+	if !ok {
+		return defaultProps(name)
+	}
+	props = map[string]interface{}{}
+	for k, v := range b.props {
+		props[k] = v
+	}
+	return
+}
+
+// Only the properties given are changed (as with riak); the name can't be.
+func (self *store) setProps(name string, props map[string]interface{}) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	b := self.bucket(name)
+	for k, v := range props {
+		if k != "name" {
+			b.props[k] = v
+		}
+	}
+}
+
+// Buckets with at least one object, sorted.
+func (self *store) bucketNames() (names []string) {
+	self.lock.Lock()
+	names = []string{}
+	for name, b := range self.buckets {
+		if len(b.objects) > 0 {
+			names = append(names, name)
+		}
+	}
+	self.lock.Unlock()
+	sort.SortStrings(names)
+	return
+}
+
+func (self *store) keys(name string) (keys []string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	keys = []string{}
+	if b, ok := self.buckets[name]; ok {
+		for k := range b.objects {
+			keys = append(keys, k)
+		}
+	}
+	sort.SortStrings(keys)
+	return
+}
+
+// returns a copy of the object (so it can be used without the lock held), or nil
+func (self *store) object(bname, key string) (o *object) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if b, ok := self.buckets[bname]; ok {
+		if cur, ok := b.objects[key]; ok {
+			o = &object{vclock: cur.vclock, siblings: append([]*sibling{}, cur.siblings...)}
+		}
+	}
+	return
+}
+
+// returns the vclock of bname/key's tombstone, if it has one
+func (self *store) tombstone(bname, key string) (vclock string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if b, ok := self.buckets[bname]; ok {
+		vclock = b.tombstones[key]
+	}
+	return
+}
+
+func (self *store) reapTombstones() {
+	self.lock.Lock()
+	for _, b := range self.buckets {
+		b.tombstones = map[string]string{}
+	}
+	self.lock.Unlock()
+}
+
+// Headers riak stores alongside the value
+func storedHeader(in http.Header) (out http.Header) {
+	out = http.Header{}
+	for k, v := range in {
+		switch {
+		case k == "Content-Type", k == "Content-Encoding", k == "Link",
+			strings.HasPrefix(k, "X-Riak-Meta-"), strings.HasPrefix(k, "X-Riak-Index-"):
+			out[k] = v
+		}
+	}
+	if out.Get("Content-Type") == "" {
+		out.Set("Content-Type", "application/octet-stream")
+	}
+	return
+}
+
+// Returns the status to respond with if a precondition in hdr fails, or 0.
+func precondition(hdr http.Header, cur *object) int {
+	if inm := hdr.Get("If-None-Match"); inm == "*" && cur != nil {
+		return http.StatusPreconditionFailed
+	}
+	if im := hdr.Get("If-Match"); im != "" {
+		if cur == nil || len(cur.siblings) != 1 || cur.siblings[0].vtag != strings.Trim(im, "\"") {
+			return http.StatusPreconditionFailed
+		}
+	}
+	return 0
+}
+
+// Stores value as a PUT with the request headers hdr would, returning the
+// new object, or the status to respond with if a precondition failed.
+func (self *store) put(bname, key string, value []byte, hdr http.Header) (o *object, status int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	b := self.bucket(bname)
+	cur := b.objects[key]
+	if status = precondition(hdr, cur); status != 0 {
+		return
+	}
+	s := &sibling{
+		value:    append([]byte{}, value...),
+		header:   storedHeader(hdr),
+		vtag:     self.nextId(),
+		modified: time.Seconds(),
+	}
+	o = &object{}
+	allowMulti, _ := b.props["allow_mult"].(bool)
+	if cur != nil && allowMulti && hdr.Get("X-Riak-Vclock") != cur.vclock {
+		// a missing or stale vclock creates a sibling
+		o.siblings = append(append(o.siblings, cur.siblings...), s)
+	} else {
+		o.siblings = []*sibling{s}
+	}
+	o.vclock = self.newVclock(bname, key)
+	b.objects[key] = o
+	b.tombstones[key] = "", false
+	return
+}
+
+// Returns whether there was an object to delete, and the vclock of the
+// key's tombstone (if any).
+func (self *store) remove(bname, key string) (found bool, tombstone string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	b, ok := self.buckets[bname]
+	if !ok {
+		return
+	}
+	_, found = b.objects[key]
+	b.objects[key] = nil, false
+	if found && self.keepTombstones {
+		b.tombstones[key] = self.newVclock(bname, key)
+	}
+	return found, b.tombstones[key]
+}
+
+// Headers sent with a sibling's value.
+func siblingHeader(bname string, s *sibling) (hdr http.Header) {
+	hdr = http.Header{}
+	for k, v := range s.header {
+		hdr[k] = v
+	}
+	hdr.Add("Link", fmt.Sprintf("</riak/%s>; rel=\"up\"", http.URLEscape(bname)))
+	hdr.Set("Etag", s.vtag)
+	hdr.Set("Last-Modified", time.SecondsToUTC(s.modified).Format(http.TimeFormat))
+	return
+}
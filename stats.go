@@ -0,0 +1,192 @@
+package riak
+
+import (
+	"http"
+	"json"
+	"os"
+	"strings"
+)
+
+// See http://wiki.basho.com/HTTP-Status.html for the full list of statistics.
+// Those not decoded into a NodeStats field are left in Extra.
+
+var ErrStatsDisabled = os.NewError("Stats are not enabled on this node (riak_kv_stat)")
+
+// FSM times are in microseconds.
+type Percentiles struct {
+	Mean   int64
+	Median int64
+	P95    int64
+	P99    int64
+	P100   int64
+}
+
+type NodeStats struct {
+	NodeName string
+	// Over the last minute
+	VnodeGets   int64
+	VnodePuts   int64
+	NodeGets    int64
+	NodePuts    int64
+	ReadRepairs int64
+	// Since the node started
+	VnodeGetsTotal   int64
+	VnodePutsTotal   int64
+	NodeGetsTotal    int64
+	NodePutsTotal    int64
+	ReadRepairsTotal int64
+
+	GetFSMTime     Percentiles
+	PutFSMTime     Percentiles
+	GetFSMSiblings Percentiles
+	GetFSMObjSize  Percentiles
+
+	// Load averages are scaled by 256, as reported by Erlang's cpu_sup
+	CPUNProcs int64
+	CPUAvg1   int64
+	CPUAvg5   int64
+	CPUAvg15  int64
+	// System memory, in bytes
+	MemTotal     int64
+	MemAllocated int64
+	// Erlang VM memory (memory_total, memory_processes, ...), by name
+	Memory map[string]int64
+
+	RingMembers       []string
+	ConnectedNodes    []string
+	RingNumPartitions int64
+	RingCreationSize  int64
+	RingOwnership     string
+	StorageBackend    string
+
+	PBCConnectsTotal int64
+	PBCActive        int64
+
+	// Application versions (riak_kv_version, ...), by application name
+	Versions map[string]string
+	// Everything else
+	Extra map[string]interface{}
+}
+
+// Takes values out of the raw map as they're decoded, so what's left is Extra.
+type statsMap map[string]interface{}
+
+func (self statsMap) intValue(name string) (i int64) {
+	if f, ok := self[name].(float64); ok {
+		i = int64(f)
+		self[name] = nil, false
+	}
+	return
+}
+
+func (self statsMap) stringValue(name string) (s string) {
+	if v, ok := self[name].(string); ok {
+		s = v
+		self[name] = nil, false
+	}
+	return
+}
+
+func (self statsMap) stringList(name string) (out []string) {
+	if v, ok := self[name].([]interface{}); ok {
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		self[name] = nil, false
+	}
+	return
+}
+
+func (self statsMap) percentiles(prefix string) Percentiles {
+	return Percentiles{
+		Mean:   self.intValue(prefix + "_mean"),
+		Median: self.intValue(prefix + "_median"),
+		P95:    self.intValue(prefix + "_95"),
+		P99:    self.intValue(prefix + "_99"),
+		P100:   self.intValue(prefix + "_100"),
+	}
+}
+
+func (self *NodeStats) UnmarshalJSON(in []byte) (err os.Error) {
+	m := statsMap{}
+	if err = json.Unmarshal(in, &m); err != nil {
+		return
+	}
+	*self = NodeStats{
+		NodeName:         m.stringValue("nodename"),
+		VnodeGets:        m.intValue("vnode_gets"),
+		VnodePuts:        m.intValue("vnode_puts"),
+		NodeGets:         m.intValue("node_gets"),
+		NodePuts:         m.intValue("node_puts"),
+		ReadRepairs:      m.intValue("read_repairs"),
+		VnodeGetsTotal:   m.intValue("vnode_gets_total"),
+		VnodePutsTotal:   m.intValue("vnode_puts_total"),
+		NodeGetsTotal:    m.intValue("node_gets_total"),
+		NodePutsTotal:    m.intValue("node_puts_total"),
+		ReadRepairsTotal: m.intValue("read_repairs_total"),
+
+		GetFSMTime:     m.percentiles("node_get_fsm_time"),
+		PutFSMTime:     m.percentiles("node_put_fsm_time"),
+		GetFSMSiblings: m.percentiles("node_get_fsm_siblings"),
+		GetFSMObjSize:  m.percentiles("node_get_fsm_objsize"),
+
+		CPUNProcs:    m.intValue("cpu_nprocs"),
+		CPUAvg1:      m.intValue("cpu_avg1"),
+		CPUAvg5:      m.intValue("cpu_avg5"),
+		CPUAvg15:     m.intValue("cpu_avg15"),
+		MemTotal:     m.intValue("mem_total"),
+		MemAllocated: m.intValue("mem_allocated"),
+
+		RingMembers:       m.stringList("ring_members"),
+		ConnectedNodes:    m.stringList("connected_nodes"),
+		RingNumPartitions: m.intValue("ring_num_partitions"),
+		RingCreationSize:  m.intValue("ring_creation_size"),
+		RingOwnership:     m.stringValue("ring_ownership"),
+		StorageBackend:    m.stringValue("storage_backend"),
+
+		PBCConnectsTotal: m.intValue("pbc_connects_total"),
+		PBCActive:        m.intValue("pbc_active"),
+
+		Memory:   map[string]int64{},
+		Versions: map[string]string{},
+	}
+	for k, v := range m {
+		switch {
+		case strings.HasPrefix(k, "memory_"):
+			if f, ok := v.(float64); ok {
+				self.Memory[k[len("memory_"):]] = int64(f)
+				m[k] = nil, false
+			}
+		case strings.HasSuffix(k, "_version"):
+			if s, ok := v.(string); ok {
+				self.Versions[k[:len(k)-len("_version")]] = s
+				m[k] = nil, false
+			}
+		}
+	}
+	self.Extra = m
+	return
+}
+
+func statsRequest(c Client) (req *http.Request) {
+	// like ping, stats aren't under /riak/
+	req = c.request("GET", c.resourcePath(ResourceStats), http.Header{"Accept": []string{"application/json"}}, nil)
+	return
+}
+
+func Stats(c Client, cc *http.ClientConn) (stats NodeStats, err os.Error) {
+	req := statsRequest(c)
+	err = dispatchRequest(c, cc, req, map[int]func(*http.Response) os.Error{
+		-1:  failf("Stats failed"),
+		404: func(*http.Response) os.Error { return ErrStatsDisabled },
+		503: func(*http.Response) os.Error { return ErrServiceUnavailable },
+		200: func(r *http.Response) (err os.Error) {
+			defer r.Body.Close()
+			err = json.NewDecoder(r.Body).Decode(&stats)
+			return
+		},
+	})
+	return
+}
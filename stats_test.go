@@ -0,0 +1,35 @@
+package riak
+
+import (
+	"json"
+	"path"
+	"testing"
+)
+
+func TestStatsRequest(t *testing.T) {
+	expPath := path.Join(TESTING_RIAK.Path, "stats")
+	c := testClient(t)
+	req := statsRequest(c)
+	fatalIf(t, req == nil, "Got a nil request back!")
+	fatalIf(t, req.Method != "GET", "Unexpected method: %s", req.Method)
+	fatalIf(t, req.URL.Path != expPath, "Unexpected path: %s", req.URL.Path)
+	fatalIf(t, req.Header.Get("Accept") != "application/json", "Unexpected accept: %s", req.Header.Get("Accept"))
+}
+
+var testStats = []byte(`{"vnode_gets":12,"node_gets_total":1000,"node_get_fsm_time_mean":1500,
+"node_get_fsm_time_95":3000,"node_put_fsm_time_100":90000,"cpu_avg1":256,"nodename":"riak@127.0.0.1",
+"connected_nodes":["riak@10.0.0.2"],"ring_members":["riak@127.0.0.1","riak@10.0.0.2"],
+"memory_total":2048,"riak_kv_version":"1.0.1","some_new_counter":7}`)
+
+func TestNodeStatsUnmarshal(t *testing.T) {
+	var s NodeStats
+	err := json.Unmarshal(testStats, &s)
+	fatalIf(t, err != nil, "Unmarshal failed: %v", err)
+	fatalIf(t, s.NodeName != "riak@127.0.0.1", "Wrong nodename: %q", s.NodeName)
+	fatalIf(t, s.VnodeGets != 12 || s.NodeGetsTotal != 1000 || s.CPUAvg1 != 256, "Wrong counters: %v", s)
+	fatalIf(t, s.GetFSMTime.Mean != 1500 || s.GetFSMTime.P95 != 3000 || s.PutFSMTime.P100 != 90000, "Wrong FSM times: %v %v", s.GetFSMTime, s.PutFSMTime)
+	fatalIf(t, len(s.RingMembers) != 2 || len(s.ConnectedNodes) != 1, "Wrong membership: %v %v", s.RingMembers, s.ConnectedNodes)
+	fatalIf(t, s.Memory["total"] != 2048, "Wrong memory: %v", s.Memory)
+	fatalIf(t, s.Versions["riak_kv"] != "1.0.1", "Wrong versions: %v", s.Versions)
+	fatalIf(t, len(s.Extra) != 1 || s.Extra["some_new_counter"] != float64(7), "Wrong extra: %v", s.Extra)
+}
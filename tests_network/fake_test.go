@@ -0,0 +1,22 @@
+package riak
+
+import (
+	"github.com/abneptis/riak/riaktest"
+	"http"
+	"os"
+)
+
+// If RIAK_FAKE is set, the network tests are run against an in-process
+// riaktest.Server rather than a local riak node.
+func init() {
+	if os.Getenv("RIAK_FAKE") == "" {
+		return
+	}
+	s := riaktest.NewServer()
+	u, err := http.ParseURL(s.URL)
+	if err != nil {
+		panic(err)
+	}
+	TESTING_RIAK.Scheme, TESTING_RIAK.Host = u.Scheme, u.Host
+	BAD_RIAK.Scheme, BAD_RIAK.Host = u.Scheme, u.Host
+}
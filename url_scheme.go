@@ -0,0 +1,36 @@
+package riak
+
+// Riak 1.0 added a second set of URLs for buckets and objects:
+//	/buckets?buckets=true       (rather than /riak?buckets=true)
+//	/buckets/B/props            (/riak/B)
+//	/buckets/B/keys?keys=true   (/riak/B?keys=true)
+//	/buckets/B/keys/K           (/riak/B/K)
+// and nodes may be configured to serve only those.
+
+type URLScheme int
+
+const (
+	// The /buckets URLs if the node advertises them (see Discover and
+	// Client.WithResources), the /riak URLs otherwise.
+	URLSchemeAuto URLScheme = iota
+	URLSchemeRiak
+	URLSchemeBuckets
+)
+
+// Returns a copy of the client using scheme.
+func (self Client) WithURLScheme(scheme URLScheme) Client {
+	self.URLScheme = scheme
+	return self
+}
+
+func (self Client) bucketsURLs() bool {
+	switch self.URLScheme {
+	case URLSchemeRiak:
+		return false
+	case URLSchemeBuckets:
+		return true
+	}
+	// riak_kv_wm_props only exists alongside the /buckets URLs
+	_, ok := self.Resources[ResourceProps]
+	return ok
+}
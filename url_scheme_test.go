@@ -0,0 +1,31 @@
+package riak
+
+import "testing"
+
+func TestBucketsURLs(t *testing.T) {
+	c := testClient(t).WithURLScheme(URLSchemeBuckets)
+	fatalIf(t, c.bucketPath("b") != "/buckets/b/props", "Wrong props path: %s", c.bucketPath("b"))
+	fatalIf(t, c.keyPath("b", "k") != "/buckets/b/keys/k", "Wrong key path: %s", c.keyPath("b", "k"))
+	fatalIf(t, c.keyPath("b", "") != "/buckets/b/keys", "Wrong key list path: %s", c.keyPath("b", ""))
+	req := getBucketRequest(c, "b", false, true)
+	fatalIf(t, req.URL.Path != "/buckets/b/keys", "Keys should be listed from /keys: %s", req.URL.Path)
+	req = getBucketRequest(c, "b", true, false)
+	fatalIf(t, req.URL.Path != "/buckets/b/props", "Props should be fetched from /props: %s", req.URL.Path)
+	req = listBucketsRequest(c)
+	fatalIf(t, req.URL.Path != "/buckets", "Wrong bucket list path: %s", req.URL.Path)
+}
+
+func TestURLSchemeAuto(t *testing.T) {
+	c := testClient(t)
+	fatalIf(t, c.bucketsURLs(), "Default client shouldn't use /buckets URLs")
+	c = c.WithResources(Resources{ResourceObject: "/riak", ResourceProps: "/buckets", ResourceKeyList: "/buckets"})
+	fatalIf(t, !c.bucketsURLs(), "Advertised /buckets resources should be used")
+	fatalIf(t, c.WithURLScheme(URLSchemeRiak).keyPath("b", "k") != "/riak/b/k", "URLSchemeRiak should override discovery")
+}
+
+func TestParseBucketsLinks(t *testing.T) {
+	links := parseLinks(`</buckets/people/keys/bob>; riaktag="friend", </riak/keys/carol>; riaktag="friend"`)
+	fatalIf(t, len(links["friend"]) != 2, "Wrong links: %v", links)
+	fatalIf(t, !sameLink(links["friend"][0], Link{"people", "bob"}), "Wrong /buckets link: %v", links["friend"][0])
+	fatalIf(t, !sameLink(links["friend"][1], Link{"keys", "carol"}), "Wrong /riak link: %v", links["friend"][1])
+}